@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// prefixList is a flag.Value holding space separated CIDR prefixes. A bare IP
+// address is a single-address prefix.
+type prefixList []netip.Prefix
+
+func (l *prefixList) String() string {
+	vals := make([]string, len(*l))
+	for i, prefix := range *l {
+		vals[i] = prefix.String()
+	}
+	return strings.Join(vals, " ")
+}
+
+func (l *prefixList) Set(val string) error {
+	var prefixes prefixList
+
+	for _, field := range strings.Fields(val) {
+		prefix, err := parsePrefix(field)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	*l = prefixes
+	return nil
+}
+
+// parsePrefix parses a CIDR prefix or a bare IP address, which is taken as a
+// single-address prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(s)
+		if addrErr != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR or IP address %q", s)
+		}
+		prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+	}
+	return prefix.Masked(), nil
+}
+
+func (l prefixList) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return slices.ContainsFunc(l, func(prefix netip.Prefix) bool {
+		return prefix.Contains(addr)
+	})
+}
+
+// resolveClientIP works out the address of the client that made r. Forwarding
+// headers are only believed when the connection comes from a trusted proxy,
+// and X-Forwarded-For is read right to left, skipping trusted proxies, so the
+// result is the last address added by a proxy we trust rather than whatever
+// the client chose to put at the front of the header.
+func resolveClientIP(r *http.Request, trusted prefixList) netip.Addr {
+	peer := parseAddr(r.RemoteAddr)
+	if !peer.IsValid() || !trusted.contains(peer) {
+		return peer
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	if len(hops) == 0 {
+		if addr := parseAddr(r.Header.Get("X-Real-IP")); addr.IsValid() {
+			return addr
+		}
+		return peer
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr := parseAddr(hops[i])
+		if !addr.IsValid() {
+			// Anything left of a malformed entry can't be trusted.
+			break
+		}
+
+		client = addr
+		if !trusted.contains(addr) {
+			break
+		}
+	}
+
+	return client
+}
+
+// parseAddr parses an IP address with or without a port, returning the zero
+// Addr if s is neither.
+func parseAddr(s string) netip.Addr {
+	s = strings.TrimSpace(s)
+
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    string
+		wantErr bool
+	}{
+		{val: "10.0.0.0/8", want: "10.0.0.0/8"},
+		{val: "10.1.2.3/8", want: "10.0.0.0/8"},
+		{val: "192.168.1.10", want: "192.168.1.10/32"},
+		{val: "::ffff:192.168.1.10", want: "192.168.1.10/32"},
+		{val: "2001:db8::/32", want: "2001:db8::/32"},
+		{val: "2001:db8::1", want: "2001:db8::1/128"},
+		{val: "", wantErr: true},
+		{val: "localhost", wantErr: true},
+		{val: "10.0.0.0/33", wantErr: true},
+		{val: "300.0.0.1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePrefix(tt.val)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parsePrefix(%q) = %s; want an error", tt.val, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePrefix(%q) error = %v", tt.val, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("parsePrefix(%q) = %s; want %s", tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestResolveClientIP(t *testing.T) {
+	var trusted prefixList
+	err := trusted.Set("10.0.0.0/8 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "203.0.113.7:5000",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "untrusted peer's headers are ignored",
+			remoteAddr: "203.0.113.7:5000",
+			forwarded:  []string{"198.51.100.1"},
+			realIP:     "198.51.100.2",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.1:5000",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "spoofed entries left of the client are ignored",
+			remoteAddr: "10.0.0.1:5000",
+			forwarded:  []string{"1.1.1.1, 198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "10.0.0.1:5000",
+			forwarded:  []string{"198.51.100.1, 192.168.1.1, 10.0.0.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "multiple headers",
+			remoteAddr: "10.0.0.1:5000",
+			forwarded:  []string{"1.1.1.1", "198.51.100.1, 10.0.0.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "malformed entry stops the walk",
+			remoteAddr: "10.0.0.1:5000",
+			forwarded:  []string{"198.51.100.1, garbage, 10.0.0.2"},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "only trusted proxies",
+			remoteAddr: "10.0.0.1:5000",
+			forwarded:  []string{"10.0.0.3, 10.0.0.2"},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "entries with ports",
+			remoteAddr: "10.0.0.1:5000",
+			forwarded:  []string{"[2001:db8::1]:443"},
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "X-Real-IP without X-Forwarded-For",
+			remoteAddr: "10.0.0.1:5000",
+			realIP:     "198.51.100.2",
+			want:       "198.51.100.2",
+		},
+		{
+			name:       "invalid X-Real-IP",
+			remoteAddr: "10.0.0.1:5000",
+			realIP:     "unknown",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "IPv4-mapped peer",
+			remoteAddr: "[::ffff:10.0.0.1]:5000",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "unparseable remote address",
+			remoteAddr: "pipe",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "invalid IP",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, val := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", val)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			got := resolveClientIP(r, trusted)
+			if got.String() != tt.want {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefixListContains(t *testing.T) {
+	var l prefixList
+	err := l.Set("10.0.0.0/8 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+
+	for _, tt := range tests {
+		if got := l.contains(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("contains(%s) = %t; want %t", tt.addr, got, tt.want)
+		}
+	}
+}
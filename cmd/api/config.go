@@ -0,0 +1,537 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+type config struct {
+	port int
+	env  string
+	// trustedProxies are the addresses whose X-Forwarded-For and X-Real-IP
+	// headers are believed when resolving the client IP.
+	trustedProxies prefixList
+	tls            struct {
+		certFile       string
+		keyFile        string
+		minVersion     string
+		cipherSuites   stringList
+		reloadInterval time.Duration
+		redirectPort   int
+	}
+	log struct {
+		format string
+		level  slog.Level
+		source bool
+	}
+	db struct {
+		dsn               string
+		maxOpenConns      int
+		minConns          int
+		maxIdleTime       time.Duration
+		maxConnLifetime   time.Duration
+		healthCheckPeriod time.Duration
+		queryTimeout      time.Duration
+		autoMigrate       bool
+	}
+	limiter struct {
+		store   string
+		rps     float64
+		burst   int
+		cleanup time.Duration
+		enabled bool
+		// groups and roles are the per route group and per role policies,
+		// loginEmail limits login attempts per target email, and concurrency
+		// caps in-flight requests per route group.
+		groups      limitSet
+		roles       limitSet
+		loginEmail  limitFlag
+		concurrency countSet
+	}
+	lockout struct {
+		threshold   int
+		ipThreshold int
+		window      time.Duration
+		duration    time.Duration
+		delay       time.Duration
+		maxDelay    time.Duration
+	}
+	clients struct {
+		// keyGracePeriod is how long a trusted client's old API keys keep
+		// working after a new one is created.
+		keyGracePeriod time.Duration
+		// logRetention is how long each trusted client request is kept
+		// before it is rolled up into daily totals.
+		logRetention time.Duration
+		// usageRetention is how long the daily totals are kept; zero
+		// keeps them forever.
+		usageRetention time.Duration
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	mail struct {
+		transport   string
+		dir         string
+		templateDir string
+		baseURL     string
+	}
+	cors struct {
+		trustedOrigins stringList
+	}
+	otel struct {
+		exporter    string
+		endpoint    string
+		insecure    bool
+		file        string
+		sampleRatio float64
+	}
+	metrics struct {
+		// addr is where Prometheus metrics are served, on a listener of
+		// their own; empty disables them.
+		addr string
+	}
+	health struct {
+		timeout       time.Duration
+		smtp          bool
+		maxJobBacklog int
+	}
+	shutdown struct {
+		drainDelay time.Duration
+	}
+	jobs struct {
+		workers      int
+		pollInterval time.Duration
+		lockTimeout  time.Duration
+		maxBackoff   time.Duration
+	}
+
+	// sources records where each setting's value came from, keyed by flag
+	// name, for --print-config.
+	sources map[string]string
+}
+
+// Flags that control the process rather than configure the server. They are
+// not read from the config file or environment and not printed.
+var commandFlags = []string{"config", "version", "print-config"}
+
+// deprecatedFlags are still accepted, so that existing deployments keep
+// starting, but no longer do anything. Setting one logs a warning.
+var deprecatedFlags = map[string]string{
+	"db-max-idle-conns": "idle connections are no longer capped; use -db-min-conns and -db-max-idle-time",
+}
+
+// secretFlags are redacted by --print-config.
+var secretFlags = []string{"db-dsn", "smtp-password"}
+
+// legacyEnv maps settings to the unprefixed environment variables read before
+// GREENLIGHT_* variables existed. The prefixed variable wins if both are set.
+var legacyEnv = map[string]string{
+	"smtp-host":     "SMTP_HOST",
+	"smtp-username": "SMTP_USERNAME",
+	"smtp-password": "SMTP_PASSWORD",
+	"smtp-sender":   "SMTP_SENDER",
+}
+
+// stringList is a flag.Value holding a space separated list.
+type stringList []string
+
+func (l *stringList) String() string       { return strings.Join(*l, " ") }
+func (l *stringList) Set(val string) error { *l = strings.Fields(val); return nil }
+
+// loadConfig builds the configuration from, in increasing order of precedence,
+// flag defaults, the YAML or TOML file named by -config (or GREENLIGHT_CONFIG),
+// environment variables and command-line flags. Every setting can be given in
+// each layer: the flag -db-max-open-conns is db.max-open-conns in the file and
+// GREENLIGHT_DB_MAX_OPEN_CONNS in the environment. A .env file is loaded into
+// the environment if present.
+func loadConfig(args []string) (config, *flag.FlagSet, error) {
+	var cfg config
+
+	err := godotenv.Load()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return cfg, nil, fmt.Errorf("load .env: %w", err)
+	}
+
+	fs := flag.NewFlagSet("greenlight", flag.ContinueOnError)
+
+	fs.String("config", os.Getenv("GREENLIGHT_CONFIG"), "Path to a YAML or TOML (.toml) config file")
+	fs.Bool("version", false, "Display version and exit")
+	fs.Bool("print-config", false, "Print the effective configuration, with secrets redacted, and exit")
+
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	fs.Var(&cfg.trustedProxies, "trusted-proxies", "CIDRs or IPs of reverse proxies whose forwarding headers are trusted (space separated)")
+	fs.StringVar(&cfg.tls.certFile, "tls-cert-file", "", "TLS certificate file (PEM); enables HTTPS together with -tls-key-file")
+	fs.StringVar(&cfg.tls.keyFile, "tls-key-file", "", "TLS private key file (PEM)")
+	fs.StringVar(&cfg.tls.minVersion, "tls-min-version", "1.2", "Minimum TLS version (1.2|1.3)")
+	fs.Var(&cfg.tls.cipherSuites, "tls-cipher-suites", "TLS 1.2 cipher suites (space separated Go names; default Go's secure suites)")
+	fs.DurationVar(&cfg.tls.reloadInterval, "tls-reload-interval", time.Minute, "How often the TLS certificate files are checked for changes")
+	fs.IntVar(&cfg.tls.redirectPort, "tls-redirect-port", 0, "Port of a plain HTTP listener redirecting to HTTPS (0 disables)")
+	fs.StringVar(&cfg.log.format, "log-format", "text", "Log format (text|json)")
+	fs.TextVar(&cfg.log.level, "log-level", slog.LevelInfo, "Minimum log level (debug|info|warn|error)")
+	fs.BoolVar(&cfg.log.source, "log-source", false, "Include the source file and line in log entries")
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.Int("db-max-idle-conns", 25, "Deprecated and ignored: pgxpool doesn't cap idle connections; see -db-min-conns and -db-max-idle-time")
+	fs.IntVar(&cfg.db.minConns, "db-min-conns", 0, "PostgreSQL connections kept open even when idle")
+	fs.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max idle time")
+	fs.DurationVar(&cfg.db.maxConnLifetime, "db-max-conn-lifetime", time.Hour, "PostgreSQL max connection lifetime")
+	fs.DurationVar(&cfg.db.healthCheckPeriod, "db-health-check-period", time.Minute, "How often idle PostgreSQL connections are health checked")
+	fs.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "Default timeout for each database query (0 disables)")
+	fs.BoolVar(&cfg.db.autoMigrate, "db-auto-migrate", false, "Apply pending migrations at startup")
+	fs.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Rate limiter state store (memory|postgres); postgres shares limits between instances")
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limit per IP, in requests per second, for route groups without a -limiter-groups policy and unmatched routes")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter burst per IP for route groups without a -limiter-groups policy and unmatched routes")
+	fs.DurationVar(&cfg.limiter.cleanup, "limiter-cleanup", 3*time.Minute, "Rate limiter cleanup time")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	cfg.limiter.groups = limitSet{"auth": {rps: 0.5, burst: 5}, "read": {rps: 10, burst: 20}, "write": {rps: 2, burst: 4}, "admin": {rps: 2, burst: 4}}
+	fs.Var(&cfg.limiter.groups, "limiter-groups", "Rate limits per route group and user, or IP when anonymous (space separated GROUP=RATE:BURST; RATE is per second or N/m, N/h)")
+	fs.Var(&cfg.limiter.roles, "limiter-roles", "Rate limits replacing the route group limits outside auth for users with a role; the most generous applies (space separated ROLE=RATE:BURST)")
+	cfg.limiter.loginEmail = limitFlag{rps: 5.0 / 60, burst: 5}
+	fs.Var(&cfg.limiter.loginEmail, "limiter-login-email", "Rate limit on login attempts per target email (RATE:BURST; empty disables)")
+	cfg.limiter.concurrency = countSet{"health": 1000, "auth": 100, "read": 200, "write": 50, "admin": 50}
+	fs.Var(&cfg.limiter.concurrency, "limiter-concurrency", "Maximum concurrent requests per route group (space separated GROUP=N; 0 disables)")
+	fs.IntVar(&cfg.lockout.threshold, "lockout-threshold", 5, "Failed logins within the lockout window that lock an account")
+	fs.IntVar(&cfg.lockout.ipThreshold, "lockout-ip-threshold", 50, "Failed logins within the lockout window after which an IP may not log in (0 disables)")
+	fs.DurationVar(&cfg.lockout.window, "lockout-window", 15*time.Minute, "Period over which failed logins are counted")
+	fs.DurationVar(&cfg.lockout.duration, "lockout-duration", 30*time.Minute, "How long an account stays locked")
+	fs.DurationVar(&cfg.lockout.delay, "lockout-delay", 250*time.Millisecond, "How long the account and IP must wait to log in again after a failed login, doubled for every recent failure (0 disables)")
+	fs.DurationVar(&cfg.lockout.maxDelay, "lockout-max-delay", 5*time.Second, "Maximum wait before logging in again after a failed login")
+	fs.DurationVar(&cfg.clients.keyGracePeriod, "client-key-grace-period", 24*time.Hour, "How long a trusted client's previous API keys keep working after a new key is created")
+	fs.DurationVar(&cfg.clients.logRetention, "client-log-retention", 30*24*time.Hour, "How long individual trusted client requests are kept before being rolled up into daily totals")
+	fs.DurationVar(&cfg.clients.usageRetention, "client-usage-retention", 0, "How long daily trusted client usage totals are kept (0 keeps them forever)")
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "", "SMTP sender")
+	fs.StringVar(&cfg.mail.transport, "mail-transport", "smtp", "Mail transport (smtp|file|memory|log); memory is for development only")
+	fs.StringVar(&cfg.mail.dir, "mail-dir", "tmp/mail", "Directory .eml files are written to by the file mail transport")
+	fs.StringVar(&cfg.mail.templateDir, "mail-template-dir", "", "Directory of email templates overriding the built-in ones")
+	fs.StringVar(&cfg.mail.baseURL, "mail-base-url", "http://localhost:4000", "Public base URL of the API used in emails")
+	fs.StringVar(&cfg.otel.exporter, "otel-exporter", "none", "Trace exporter (none|otlp|stdout|file)")
+	fs.StringVar(&cfg.otel.endpoint, "otel-endpoint", "", "OTLP/HTTP collector endpoint (host:port); defaults to OTEL_EXPORTER_OTLP_ENDPOINT")
+	fs.BoolVar(&cfg.otel.insecure, "otel-insecure", false, "Use plain HTTP for the OTLP exporter")
+	fs.StringVar(&cfg.otel.file, "otel-file", "tmp/traces.jsonl", "File spans are appended to by the file trace exporter")
+	fs.Float64Var(&cfg.otel.sampleRatio, "otel-sample-ratio", 1, "Fraction of new traces to sample (0-1)")
+	fs.IntVar(&cfg.jobs.workers, "jobs-workers", 4, "Number of background job workers")
+	fs.DurationVar(&cfg.jobs.pollInterval, "jobs-poll-interval", time.Second, "How often idle job workers poll for new jobs")
+	fs.DurationVar(&cfg.jobs.lockTimeout, "jobs-lock-timeout", 10*time.Minute, "How long a running job may go without finishing before it is reclaimed")
+	fs.DurationVar(&cfg.jobs.maxBackoff, "jobs-max-backoff", time.Hour, "Maximum delay between job retries")
+	fs.StringVar(&cfg.metrics.addr, "metrics-addr", "localhost:4001", "Address of the internal listener serving Prometheus metrics on /metrics (empty disables)")
+	fs.DurationVar(&cfg.health.timeout, "health-timeout", 2*time.Second, "Timeout for the readiness checks")
+	fs.BoolVar(&cfg.health.smtp, "health-check-smtp", false, "Include SMTP server reachability in the readiness checks")
+	fs.IntVar(&cfg.health.maxJobBacklog, "health-max-job-backlog", 1000, "Number of due jobs above which the server reports not ready (0 disables)")
+	fs.DurationVar(&cfg.shutdown.drainDelay, "shutdown-drain-delay", 0, "How long to keep serving, while reporting not ready, before shutting down")
+	fs.Var(&cfg.cors.trustedOrigins, "cors-trusted-origins", "Trusted CORS origins (space separated)")
+
+	// The first pass only finds -config; flags are parsed again at the end so
+	// that they override the file and environment.
+	err = fs.Parse(args)
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	// Remember which flags were given explicitly before the file and
+	// environment layers mark more flags as set.
+	var explicit []string
+	fs.Visit(func(f *flag.Flag) {
+		explicit = append(explicit, f.Name)
+	})
+
+	cfg.sources = make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		cfg.sources[f.Name] = "default"
+	})
+
+	if path := fs.Lookup("config").Value.String(); path != "" {
+		err = applyConfigFile(fs, path, cfg.sources)
+		if err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	err = applyEnv(fs, cfg.sources)
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return cfg, nil, err
+	}
+	for _, name := range explicit {
+		cfg.sources[name] = "flag"
+	}
+
+	return cfg, fs, nil
+}
+
+// applyConfigFile sets flags from a YAML file, or a TOML file if its name ends
+// in .toml. Nested keys are joined with dashes to form the flag name, so
+// db: {max-open-conns: 10} or [db] max-open-conns = 10 sets
+// -db-max-open-conns. Lists are joined with spaces.
+func applyConfigFile(fs *flag.FlagSet, path string, sources map[string]string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	var doc map[string]any
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(b, &doc)
+	} else {
+		err = yaml.Unmarshal(b, &doc)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flattenConfig("", doc, values)
+
+	var errs []error
+	for name, val := range values {
+		if fs.Lookup(name) == nil || slices.Contains(commandFlags, name) {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, name))
+			continue
+		}
+
+		err := fs.Set(name, val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, name, err))
+			continue
+		}
+		sources[name] = "file"
+	}
+
+	return errors.Join(errs...)
+}
+
+func flattenConfig(prefix string, node map[string]any, out map[string]string) {
+	for key, val := range node {
+		name := key
+		if prefix != "" {
+			name = prefix + "-" + key
+		}
+
+		switch v := val.(type) {
+		case map[string]any:
+			flattenConfig(name, v, out)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			out[name] = strings.Join(items, " ")
+		case nil:
+			out[name] = ""
+		default:
+			out[name] = fmt.Sprint(v)
+		}
+	}
+}
+
+// applyEnv sets flags from GREENLIGHT_<NAME> environment variables, falling
+// back to the legacy unprefixed names.
+func applyEnv(fs *flag.FlagSet, sources map[string]string) error {
+	var errs []error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(commandFlags, f.Name) {
+			return
+		}
+
+		name := envName(f.Name)
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			if name, ok = legacyEnv[f.Name]; ok {
+				val, ok = os.LookupEnv(name)
+			}
+		}
+		if !ok {
+			return
+		}
+
+		err := fs.Set(f.Name, val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		sources[f.Name] = "env"
+	})
+
+	return errors.Join(errs...)
+}
+
+func envName(flagName string) string {
+	return "GREENLIGHT_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// printConfig writes every setting with its effective value and source.
+func printConfig(w io.Writer, fs *flag.FlagSet, sources map[string]string) {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !slices.Contains(commandFlags, f.Name) {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+
+	for _, name := range names {
+		val := fs.Lookup(name).Value.String()
+		if slices.Contains(secretFlags, name) {
+			val = redact(val)
+		}
+		fmt.Fprintf(w, "%-24s %-48q %s\n", name, val, sources[name])
+	}
+}
+
+var dsnPasswordRX = regexp.MustCompile(`(password=)\S+`)
+
+// redact hides a secret value. For DSNs only the password is hidden so that
+// the host and database can still be checked.
+func redact(val string) string {
+	if val == "" {
+		return ""
+	}
+
+	if u, err := url.Parse(val); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+		return u.String()
+	}
+
+	if dsnPasswordRX.MatchString(val) {
+		return dsnPasswordRX.ReplaceAllString(val, "${1}REDACTED")
+	}
+
+	return "REDACTED"
+}
+
+// validate checks the configuration and reports every problem at once.
+func (cfg *config) validate() error {
+	var errs []error
+
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.port >= 1 && cfg.port <= 65535, "invalid port number: %d", cfg.port)
+	check(slices.Contains([]string{"development", "staging", "production"}, cfg.env), "invalid environment: %s", cfg.env)
+	if cfg.tls.certFile != "" || cfg.tls.keyFile != "" {
+		check(cfg.tls.certFile != "" && cfg.tls.keyFile != "", "TLS requires both a certificate and a key file")
+		check(cfg.tls.reloadInterval > 0, "invalid TLS reload interval: %s", cfg.tls.reloadInterval)
+
+		_, ok := tlsVersions[cfg.tls.minVersion]
+		check(ok, "invalid minimum TLS version: %s", cfg.tls.minVersion)
+
+		if len(cfg.tls.cipherSuites) > 0 {
+			_, err := cipherSuiteIDs(cfg.tls.cipherSuites)
+			check(err == nil, "invalid TLS cipher suites: %v", err)
+			check(cfg.tls.minVersion != "1.3", "TLS cipher suites cannot be configured when the minimum version is 1.3")
+			check(slices.ContainsFunc(cfg.tls.cipherSuites, func(name string) bool {
+				return slices.Contains(http2CipherSuites, name)
+			}), "TLS cipher suites must include one of %s for HTTP/2", strings.Join(http2CipherSuites, ", "))
+		}
+
+		check(cfg.tls.redirectPort >= 0 && cfg.tls.redirectPort <= 65535 && cfg.tls.redirectPort != cfg.port, "invalid TLS redirect port: %d", cfg.tls.redirectPort)
+	} else {
+		check(cfg.tls.redirectPort == 0, "the HTTPS redirect listener requires TLS to be enabled")
+	}
+
+	check(slices.Contains([]string{"text", "json"}, cfg.log.format), "invalid log format: %s", cfg.log.format)
+
+	check(cfg.db.dsn != "", "database DSN is required")
+	check(cfg.db.maxOpenConns >= 1, "invalid database max open connections: %d", cfg.db.maxOpenConns)
+	check(cfg.db.minConns >= 0 && cfg.db.minConns <= cfg.db.maxOpenConns, "invalid database min connections: %d (must be between 0 and max open connections)", cfg.db.minConns)
+	check(cfg.db.maxIdleTime >= 0, "invalid database max idle time: %s", cfg.db.maxIdleTime)
+	check(cfg.db.maxConnLifetime >= 0, "invalid database max connection lifetime: %s", cfg.db.maxConnLifetime)
+	check(cfg.db.healthCheckPeriod > 0, "invalid database health check period: %s", cfg.db.healthCheckPeriod)
+	check(cfg.db.queryTimeout >= 0, "invalid database query timeout: %s", cfg.db.queryTimeout)
+
+	check(slices.Contains([]string{"memory", "postgres"}, cfg.limiter.store), "invalid rate limiter store: %s", cfg.limiter.store)
+	check(cfg.limiter.rps > 0, "invalid rate limiter rps: %g", cfg.limiter.rps)
+	check(cfg.limiter.burst >= 1, "invalid rate limiter burst: %d", cfg.limiter.burst)
+	for _, name := range slices.Sorted(maps.Keys(cfg.limiter.groups)) {
+		check(slices.Contains(routeGroups, name), "invalid rate limiter route group: %s", name)
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.limiter.concurrency)) {
+		check(slices.Contains(routeGroups, name), "invalid concurrency route group: %s", name)
+	}
+	check(cfg.limiter.cleanup > 0, "invalid rate limiter cleanup interval: %s", cfg.limiter.cleanup)
+
+	check(cfg.lockout.threshold >= 1, "invalid lockout threshold: %d", cfg.lockout.threshold)
+	check(cfg.lockout.ipThreshold >= 0, "invalid lockout IP threshold: %d", cfg.lockout.ipThreshold)
+	check(cfg.lockout.window > 0, "invalid lockout window: %s", cfg.lockout.window)
+	check(cfg.lockout.duration > 0, "invalid lockout duration: %s", cfg.lockout.duration)
+	check(cfg.lockout.delay >= 0 && cfg.lockout.delay <= cfg.lockout.maxDelay, "invalid lockout delay: %s (must be between 0 and the maximum delay)", cfg.lockout.delay)
+
+	check(cfg.clients.keyGracePeriod >= 0, "invalid client key grace period: %s", cfg.clients.keyGracePeriod)
+	check(cfg.clients.logRetention >= 24*time.Hour, "client log retention must be at least 24h: %s", cfg.clients.logRetention)
+	check(cfg.clients.usageRetention == 0 || cfg.clients.usageRetention >= cfg.clients.logRetention,
+		"client usage retention must be 0 or at least the client log retention: %s", cfg.clients.usageRetention)
+
+	check(slices.Contains([]string{"none", "otlp", "stdout", "file"}, cfg.otel.exporter), "invalid tracing exporter: %s", cfg.otel.exporter)
+	check(cfg.otel.sampleRatio >= 0 && cfg.otel.sampleRatio <= 1, "invalid trace sample ratio: %g", cfg.otel.sampleRatio)
+
+	if cfg.metrics.addr != "" {
+		_, port, err := net.SplitHostPort(cfg.metrics.addr)
+		check(err == nil && port != "", "invalid metrics address: %s", cfg.metrics.addr)
+	}
+
+	check(cfg.health.timeout > 0, "invalid readiness check timeout: %s", cfg.health.timeout)
+
+	check(cfg.jobs.workers >= 1, "invalid number of job workers: %d", cfg.jobs.workers)
+	check(cfg.jobs.lockTimeout >= 10*time.Second, "job lock timeout must be at least 10s: %s", cfg.jobs.lockTimeout)
+
+	switch cfg.mail.transport {
+	case "smtp":
+		// The SMTP settings only matter when mail actually goes out over SMTP.
+		check(cfg.smtp.sender != "", "SMTP sender is required")
+		check(cfg.smtp.host != "", "SMTP host is required")
+		check(cfg.smtp.port >= 1 && cfg.smtp.port <= 65535, "invalid SMTP port number: %d", cfg.smtp.port)
+		check(cfg.smtp.username != "", "SMTP username is required")
+		check(cfg.smtp.password != "", "SMTP password is required")
+	case "file":
+		check(cfg.mail.dir != "", "mail directory is required for the file mail transport")
+	case "memory":
+		// Captured messages, activation and unlock tokens included, are
+		// kept for the life of the process.
+		check(cfg.env == "development", "the memory mail transport is only allowed in development")
+	case "log":
+	default:
+		check(false, "invalid mail transport: %s", cfg.mail.transport)
+	}
+
+	if cfg.smtp.sender == "" && cfg.mail.transport != "smtp" {
+		cfg.smtp.sender = "Greenlight <no-reply@greenlight.local>"
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigLayering(t *testing.T) {
+	const yamlFile = `
+port: 5000
+db:
+  max-open-conns: 10
+  max-idle-time: 5m
+cors:
+  trusted-origins:
+    - https://a.example
+    - https://b.example
+`
+
+	const tomlFile = `
+port = 5000
+cors.trusted-origins = ["https://a.example", "https://b.example"]
+
+[db]
+max-open-conns = 10
+max-idle-time = "5m"
+`
+
+	type want struct {
+		port           int
+		maxOpenConns   int
+		maxIdleTime    time.Duration
+		trustedOrigins string
+		smtpHost       string
+		sources        map[string]string
+	}
+
+	tests := []struct {
+		name string
+		file string // config file name and contents, if any
+		body string
+		env  map[string]string
+		args []string
+		want want
+	}{
+		{
+			name: "defaults",
+			want: want{
+				port:         4000,
+				maxOpenConns: 25,
+				maxIdleTime:  15 * time.Minute,
+				sources:      map[string]string{"port": "default", "db-max-open-conns": "default"},
+			},
+		},
+		{
+			name: "yaml file",
+			file: "greenlight.yaml",
+			body: yamlFile,
+			want: want{
+				port:           5000,
+				maxOpenConns:   10,
+				maxIdleTime:    5 * time.Minute,
+				trustedOrigins: "https://a.example https://b.example",
+				sources:        map[string]string{"port": "file", "db-max-open-conns": "file", "db-min-conns": "default"},
+			},
+		},
+		{
+			name: "toml file",
+			file: "greenlight.toml",
+			body: tomlFile,
+			want: want{
+				port:           5000,
+				maxOpenConns:   10,
+				maxIdleTime:    5 * time.Minute,
+				trustedOrigins: "https://a.example https://b.example",
+				sources:        map[string]string{"port": "file", "db-max-open-conns": "file", "cors-trusted-origins": "file"},
+			},
+		},
+		{
+			name: "environment overrides file",
+			file: "greenlight.yaml",
+			body: yamlFile,
+			env:  map[string]string{"GREENLIGHT_PORT": "6000", "GREENLIGHT_DB_MAX_IDLE_TIME": "1m"},
+			want: want{
+				port:           6000,
+				maxOpenConns:   10,
+				maxIdleTime:    time.Minute,
+				trustedOrigins: "https://a.example https://b.example",
+				sources:        map[string]string{"port": "env", "db-max-open-conns": "file", "db-max-idle-time": "env"},
+			},
+		},
+		{
+			name: "flags override environment and file",
+			file: "greenlight.toml",
+			body: tomlFile,
+			env:  map[string]string{"GREENLIGHT_PORT": "6000"},
+			args: []string{"-port=7000", "-db-max-open-conns=3"},
+			want: want{
+				port:           7000,
+				maxOpenConns:   3,
+				maxIdleTime:    5 * time.Minute,
+				trustedOrigins: "https://a.example https://b.example",
+				sources:        map[string]string{"port": "flag", "db-max-open-conns": "flag", "db-max-idle-time": "file"},
+			},
+		},
+		{
+			name: "legacy environment variable",
+			env:  map[string]string{"SMTP_HOST": "legacy.example"},
+			want: want{
+				port:         4000,
+				maxOpenConns: 25,
+				maxIdleTime:  15 * time.Minute,
+				smtpHost:     "legacy.example",
+				sources:      map[string]string{"smtp-host": "env"},
+			},
+		},
+		{
+			name: "prefixed environment variable beats legacy one",
+			env:  map[string]string{"SMTP_HOST": "legacy.example", "GREENLIGHT_SMTP_HOST": "new.example"},
+			want: want{
+				port:         4000,
+				maxOpenConns: 25,
+				maxIdleTime:  15 * time.Minute,
+				smtpHost:     "new.example",
+				sources:      map[string]string{"smtp-host": "env"},
+			},
+		},
+		{
+			name: "deprecated flag is still accepted",
+			args: []string{"-db-max-idle-conns=10"},
+			want: want{
+				port:         4000,
+				maxOpenConns: 25,
+				maxIdleTime:  15 * time.Minute,
+				sources:      map[string]string{"db-max-idle-conns": "flag"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GREENLIGHT_CONFIG", "")
+			// Unset the legacy variable; t.Setenv restores it afterwards.
+			t.Setenv("SMTP_HOST", "")
+			os.Unsetenv("SMTP_HOST")
+			for key, val := range tt.env {
+				t.Setenv(key, val)
+			}
+
+			args := tt.args
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), tt.file)
+				err := os.WriteFile(path, []byte(tt.body), 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"-config", path}, args...)
+			}
+
+			cfg, _, err := loadConfig(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.port != tt.want.port {
+				t.Errorf("port = %d; want %d", cfg.port, tt.want.port)
+			}
+			if cfg.db.maxOpenConns != tt.want.maxOpenConns {
+				t.Errorf("db.maxOpenConns = %d; want %d", cfg.db.maxOpenConns, tt.want.maxOpenConns)
+			}
+			if cfg.db.maxIdleTime != tt.want.maxIdleTime {
+				t.Errorf("db.maxIdleTime = %s; want %s", cfg.db.maxIdleTime, tt.want.maxIdleTime)
+			}
+			if got := strings.Join(cfg.cors.trustedOrigins, " "); got != tt.want.trustedOrigins {
+				t.Errorf("cors.trustedOrigins = %q; want %q", got, tt.want.trustedOrigins)
+			}
+			if cfg.smtp.host != tt.want.smtpHost {
+				t.Errorf("smtp.host = %q; want %q", cfg.smtp.host, tt.want.smtpHost)
+			}
+			for name, source := range tt.want.sources {
+				if cfg.sources[name] != source {
+					t.Errorf("source of %s = %q; want %q", name, cfg.sources[name], source)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigRejectsUnknownFileSettings(t *testing.T) {
+	t.Setenv("GREENLIGHT_CONFIG", "")
+
+	for name, body := range map[string]string{
+		"greenlight.yaml": "db:\n  max-connections: 10\n",
+		"greenlight.toml": "[db]\nmax-connections = 10\n",
+	} {
+		path := filepath.Join(t.TempDir(), name)
+		err := os.WriteFile(path, []byte(body), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = loadConfig([]string{"-config", path})
+		if err == nil || !strings.Contains(err.Error(), `unknown setting "db-max-connections"`) {
+			t.Errorf("%s: got error %v; want unknown setting", name, err)
+		}
+	}
+}
+
+func TestValidateMemoryMailTransport(t *testing.T) {
+	t.Setenv("GREENLIGHT_CONFIG", "")
+
+	tests := []struct {
+		env     string
+		wantErr bool
+	}{
+		{env: "development"},
+		{env: "staging", wantErr: true},
+		{env: "production", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		cfg, _, err := loadConfig([]string{"-env", tt.env, "-mail-transport", "memory"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Other settings may be invalid too; only this problem matters here.
+		err = cfg.validate()
+		rejected := err != nil && strings.Contains(err.Error(), "memory mail transport")
+		if rejected != tt.wantErr {
+			t.Errorf("%s: got error %v; want rejected %t", tt.env, err, tt.wantErr)
+		}
+	}
+}
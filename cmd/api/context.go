@@ -3,13 +3,25 @@ package main
 import (
 	"context"
 	"net/http"
+	"net/netip"
+	"sync/atomic"
 
 	"github.com/shadyar-bakr/greenlight/internal/data"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey     = contextKey("user")
+	clientContextKey   = contextKey("client")
+	clientIPContextKey = contextKey("client_ip")
+	// requestClientContextKey holds the ID of the trusted client making the
+	// request, for logClientRequest.
+	requestClientContextKey = contextKey("request_client")
+	// apiKeyClientContextKey holds the trusted client found by the request's
+	// API key, so that it is only looked up once.
+	apiKeyClientContextKey = contextKey("api_key_client")
+)
 
 func (app *application) contextGetUser(r *http.Request) *data.User {
 	user, ok := r.Context().Value(userContextKey).(*data.User)
@@ -23,3 +35,67 @@ func (app *application) contextSetUser(r *http.Request, user *data.User) *http.R
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
+
+func (app *application) contextSetClient(r *http.Request, client *clientPrincipal) *http.Request {
+	ctx := context.WithValue(r.Context(), clientContextKey, client)
+	return r.WithContext(ctx)
+}
+
+// contextGetClient returns the trusted client the request is authenticated
+// as, or nil if it isn't made by a client acting on its own behalf. The user
+// is always anonymous for client requests.
+func (app *application) contextGetClient(r *http.Request) *clientPrincipal {
+	client, _ := r.Context().Value(clientContextKey).(*clientPrincipal)
+	return client
+}
+
+// contextTrackClient returns r with room for the ID of the trusted client
+// making it, along with that room. It is filled in by contextNoteClient once
+// the client is known, which may be further down the middleware chain.
+func (app *application) contextTrackClient(r *http.Request) (*http.Request, *atomic.Int64) {
+	clientID := new(atomic.Int64)
+	ctx := context.WithValue(r.Context(), requestClientContextKey, clientID)
+	return r.WithContext(ctx), clientID
+}
+
+// contextNoteClient records that the request is made by the trusted client. It
+// does nothing if the request isn't tracked.
+func (app *application) contextNoteClient(r *http.Request, clientID int64) {
+	if ref, ok := r.Context().Value(requestClientContextKey).(*atomic.Int64); ok {
+		ref.Store(clientID)
+	}
+}
+
+// apiKeyLookup is the outcome of looking up a request's API key.
+type apiKeyLookup struct {
+	client *data.TrustedClient
+	err    error
+}
+
+func (app *application) contextSetAPIKeyClient(r *http.Request, client *data.TrustedClient, err error) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyClientContextKey, &apiKeyLookup{client: client, err: err})
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKeyClient returns the outcome of an earlier lookup of the
+// request's API key, or nil if there wasn't one.
+func (app *application) contextGetAPIKeyClient(r *http.Request) *apiKeyLookup {
+	lookup, _ := r.Context().Value(apiKeyClientContextKey).(*apiKeyLookup)
+	return lookup
+}
+
+func (app *application) contextSetClientIP(r *http.Request, ip netip.Addr) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+// contextGetClientIP returns the client address resolved by the clientIP
+// middleware. It is the zero Addr if RemoteAddr isn't an IP address, as with
+// some test transports.
+func (app *application) contextGetClientIP(r *http.Request) netip.Addr {
+	ip, ok := r.Context().Value(clientIPContextKey).(netip.Addr)
+	if !ok {
+		panic("missing client IP value in request context")
+	}
+	return ip
+}
@@ -11,8 +11,10 @@ const (
 	ERRCODE_RATE_LIMIT         = "RATE_LIMIT_EXCEEDED"
 	ERRCODE_INVALID_CREDS      = "INVALID_CREDENTIALS"
 	ERRCODE_INVALID_TOKEN      = "INVALID_TOKEN"
+	ERRCODE_INVALID_API_KEY    = "INVALID_API_KEY"
 	ERRCODE_AUTH_REQUIRED      = "AUTH_REQUIRED"
 	ERRCODE_INACTIVE_ACCOUNT   = "INACTIVE_ACCOUNT"
+	ERRCODE_ACCOUNT_LOCKED     = "ACCOUNT_LOCKED"
 	ERRCODE_NOT_PERMITTED      = "NOT_PERMITTED"
 	ERRCODE_TOKEN_EXPIRED      = "TOKEN_EXPIRED"
 	ERRCODE_REQUEST_TOO_LARGE  = "REQUEST_TOO_LARGE"
@@ -3,15 +3,20 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shadyar-bakr/greenlight/internal/logging"
 )
 
 func (app *application) logError(r *http.Request, err error) {
 	var (
 		method = r.Method
 		uri    = r.URL.RequestURI()
+		route  = routePattern(r)
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
+	logging.FromContext(r.Context()).ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri, "route", route)
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message any, details interface{}) {
@@ -58,6 +63,8 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, ERRCODE_EDIT_CONFLICT, message, nil)
 }
 
+// rateLimitExceededResponse sends a 429. The caller sets Retry-After and the
+// RateLimit-* headers.
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, ERRCODE_RATE_LIMIT, message, nil)
@@ -84,11 +91,34 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, ERRCODE_INACTIVE_ACCOUNT, message, nil)
 }
 
+// accountLockedResponse reports that login is refused until the lockout
+// expires or the account is unlocked.
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request, until time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(time.Until(until)), 1)))
+	message := "your user account is temporarily locked after too many failed login attempts; check your email to unlock it"
+	app.errorResponse(w, r, http.StatusLocked, ERRCODE_ACCOUNT_LOCKED, message, map[string]any{"locked_until": until})
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, ERRCODE_NOT_PERMITTED, message, nil)
 }
 
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or disabled API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, ERRCODE_INVALID_API_KEY, message, nil)
+}
+
+func (app *application) expiredAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "API key has expired"
+	app.errorResponse(w, r, http.StatusUnauthorized, ERRCODE_TOKEN_EXPIRED, message, nil)
+}
+
+func (app *application) clientIPNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this client isn't allowed to authenticate from your IP address"
+	app.errorResponse(w, r, http.StatusForbidden, ERRCODE_NOT_PERMITTED, message, nil)
+}
+
 func (app *application) expiredAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	message := "authentication token has expired"
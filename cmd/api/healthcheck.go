@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"sync"
+	"time"
 )
 
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -18,3 +22,142 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// liveHandler reports that the process is up and serving requests. It doesn't
+// touch any dependency, so a failing database never gets the process killed.
+func (app *application) liveHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// componentStatus is the result of a single readiness check.
+type componentStatus struct {
+	Status    string         `json:"status"`
+	LatencyMS float64        `json:"latency_ms"`
+	Error     string         `json:"error,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// readinessCheck returns optional details about the component, or an error if
+// the component is not ready.
+type readinessCheck func(ctx context.Context) (map[string]any, error)
+
+// readyHandler runs every readiness check concurrently and responds with 503
+// if any of them fails, or if the server is draining connections during
+// shutdown.
+func (app *application) readyHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]readinessCheck{
+		"database":   app.checkDatabase,
+		"migrations": app.checkMigrations,
+		"jobs":       app.checkJobs,
+	}
+	if app.config.health.smtp {
+		checks["smtp"] = app.checkSMTP
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.health.timeout)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]componentStatus, len(checks))
+		ready   = true
+	)
+
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			details, err := check(ctx)
+
+			result := componentStatus{
+				Status:    "up",
+				LatencyMS: float64(time.Since(start)) / float64(time.Millisecond),
+				Details:   details,
+			}
+			if err != nil {
+				result.Status = "down"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+			if err != nil {
+				ready = false
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	status, code := "ready", http.StatusOK
+	switch {
+	case app.draining.Load():
+		status, code = "draining", http.StatusServiceUnavailable
+	case !ready:
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	err := app.writeJSON(w, code, envelope{"status": status, "checks": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) checkDatabase(ctx context.Context) (map[string]any, error) {
+	err := app.db.Ping(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := app.db.Stat()
+	return map[string]any{
+		"acquired_conns": stat.AcquiredConns(),
+		"idle_conns":     stat.IdleConns(),
+		"max_conns":      stat.MaxConns(),
+	}, nil
+}
+
+func (app *application) checkMigrations(ctx context.Context) (map[string]any, error) {
+	current, err := app.models.Schema.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]any{
+		"current":  current,
+		"expected": app.schemaVersion,
+	}
+	if current != app.schemaVersion {
+		return details, fmt.Errorf("schema version %d does not match expected version %d", current, app.schemaVersion)
+	}
+
+	return details, nil
+}
+
+func (app *application) checkJobs(ctx context.Context) (map[string]any, error) {
+	backlog, oldest, err := app.models.Jobs.Backlog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]any{
+		"backlog":        backlog,
+		"oldest_seconds": oldest.Seconds(),
+	}
+	if app.config.health.maxJobBacklog > 0 && backlog > app.config.health.maxJobBacklog {
+		return details, fmt.Errorf("job backlog of %d exceeds limit of %d", backlog, app.config.health.maxJobBacklog)
+	}
+
+	return details, nil
+}
+
+func (app *application) checkSMTP(ctx context.Context) (map[string]any, error) {
+	return nil, app.mailer.Ping(ctx)
+}
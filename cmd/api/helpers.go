@@ -9,9 +9,12 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/shadyar-bakr/greenlight/internal/data"
 	"github.com/shadyar-bakr/greenlight/internal/validator"
+	"golang.org/x/text/language"
 )
 
 type envelope map[string]any
@@ -41,6 +44,34 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// unmatchedRoute stands in for the route of a request that matches none.
+const unmatchedRoute = "unmatched"
+
+// routePattern returns the pattern of the route r was routed to, such as
+// /v1/movies/{id}, so that logs and metrics group requests by endpoint rather
+// than by every ID ever requested. Requests turned away by middleware before
+// reaching the router are looked up in the routing tree instead. It returns
+// unmatchedRoute if there is no such route.
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return unmatchedRoute
+	}
+
+	if route := rctx.RoutePattern(); route != "" && !strings.HasSuffix(route, "/*") {
+		return route
+	}
+
+	// Not routed yet, or only as far as a sub-router that found nothing.
+	if rctx.Routes != nil {
+		if route := rctx.Routes.Find(chi.NewRouteContext(), r.Method, r.URL.Path); route != "" {
+			return route
+		}
+	}
+
+	return unmatchedRoute
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
@@ -135,3 +166,56 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 	return i
 }
+
+// readTime reads an RFC 3339 timestamp from the query string.
+func (app *application) readTime(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return defaultValue
+	}
+
+	return t
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// multipleLanguages is what the Accept-Language wildcard "*" parses as.
+var multipleLanguages = language.MustParse("mul")
+
+// acceptLanguage returns the client's most preferred language from the
+// Accept-Language header, or defaultValue if there is none. Extensions and
+// variants such as en-u-co-phonebk are dropped, and defaultValue is also
+// returned if what is left isn't a locale a user could have given themselves.
+func (app *application) acceptLanguage(r *http.Request, defaultValue string) string {
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(tags) == 0 || tags[0] == language.Und {
+		return defaultValue
+	}
+
+	tag, err := language.Compose(tags[0].Raw())
+	if err != nil || tag == multipleLanguages {
+		return defaultValue
+	}
+
+	locale := tag.String()
+
+	v := validator.New()
+	if data.ValidateLocale(v, locale); !v.Valid() {
+		return defaultValue
+	}
+
+	return locale
+}
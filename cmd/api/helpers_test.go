@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAcceptLanguage(t *testing.T) {
+	app := &application{}
+
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: "en"},
+		{header: "fr", want: "fr"},
+		{header: "pt-BR,pt;q=0.8", want: "pt-BR"},
+		{header: "de;q=0.5, es-MX", want: "es-MX"},
+		{header: "zh-Hant-TW", want: "zh-Hant-TW"},
+		{header: "en-u-co-phonebk", want: "en"},
+		{header: "de-DE-u-co-phonebk", want: "de-DE"},
+		{header: "sl-rozaj-biske", want: "sl"},
+		{header: "*", want: "en"},
+		{header: "not a language", want: "en"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			r.Header.Set("Accept-Language", tt.header)
+		}
+
+		got := app.acceptLanguage(r, "en")
+		if got != tt.want {
+			t.Errorf("acceptLanguage(%q) = %q; want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRoutePattern(t *testing.T) {
+	var before, after string
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			before = routePattern(r)
+			next.ServeHTTP(w, r)
+			after = routePattern(r)
+		})
+	})
+	r.Route("/v1", func(r chi.Router) {
+		r.Get("/movies/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	tests := []struct {
+		method, path string
+		want         string
+	}{
+		{http.MethodGet, "/v1/movies/1", "/v1/movies/{id}"},
+		{http.MethodGet, "/v1/nothing", unmatchedRoute},
+		{http.MethodPost, "/v1/movies/1", unmatchedRoute},
+	}
+
+	for _, tt := range tests {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+
+		// Before routing the pattern is looked up, as for requests that
+		// middleware rejects.
+		if before != tt.want {
+			t.Errorf("%s %s before routing = %q; want %q", tt.method, tt.path, before, tt.want)
+		}
+		if after != tt.want {
+			t.Errorf("%s %s after routing = %q; want %q", tt.method, tt.path, after, tt.want)
+		}
+	}
+
+	if got := routePattern(httptest.NewRequest(http.MethodGet, "/", nil)); got != unmatchedRoute {
+		t.Errorf("without a router = %q; want %q", got, unmatchedRoute)
+	}
+}
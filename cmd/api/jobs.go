@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/shadyar-bakr/greenlight/internal/data"
+	"github.com/shadyar-bakr/greenlight/internal/jobs"
+	"github.com/shadyar-bakr/greenlight/internal/logging"
+	"github.com/shadyar-bakr/greenlight/internal/validator"
+)
+
+// Job kinds handled by the worker pool.
+const (
+	jobSendEmail    = "send_email"
+	jobImportMovies = "import_movies"
+)
+
+type sendEmailPayload struct {
+	Recipient string         `json:"recipient"`
+	Locale    string         `json:"locale"`
+	Template  string         `json:"template"`
+	Data      map[string]any `json:"data"`
+}
+
+type importMoviesPayload struct {
+	Movies []*data.Movie `json:"movies"`
+}
+
+func (app *application) registerJobHandlers() {
+	jobs.Register(app.queue, jobSendEmail, app.sendEmailJob)
+	jobs.Register(app.queue, jobImportMovies, app.importMoviesJob)
+}
+
+func (app *application) sendEmailJob(ctx context.Context, payload sendEmailPayload) error {
+	err := app.mailer.Send(ctx, payload.Recipient, payload.Locale, payload.Template, payload.Data)
+	if err != nil {
+		app.instruments.mailSent.WithLabelValues(payload.Template, "failure").Inc()
+		return err
+	}
+
+	app.instruments.mailSent.WithLabelValues(payload.Template, "success").Inc()
+	return nil
+}
+
+func (app *application) importMoviesJob(ctx context.Context, payload importMoviesPayload) error {
+	count, err := app.models.Movies.InsertMany(ctx, payload.Movies)
+	if err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx).InfoContext(ctx, "imported movies", "count", count)
+	return nil
+}
+
+func (app *application) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		Kind   string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.Kind = app.readString(qs, "kind", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.SortBy = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafeList = []string{"id", "run_at", "updated_at", "-id", "-run_at", "-updated_at"}
+
+	data.ValidateJobStatus(v, input.Status)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	jobs, metadata, err := app.models.Jobs.GetAll(r.Context(), input.Status, input.Kind, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"jobs": jobs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.models.Jobs.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) retryJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Only dead jobs can be retried; anything else is reported as not found.
+	job, err := app.models.Jobs.Retry(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
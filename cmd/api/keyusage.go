@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// keyUsageFlushInterval is how often API key usage is written to the
+// database, which is as stale as last_used_at gets.
+const keyUsageFlushInterval = time.Minute
+
+// keyUsage collects when trusted client API keys are used so that it can be
+// written out in batches rather than costing a write on every request.
+type keyUsage struct {
+	mu   sync.Mutex
+	used map[int64]time.Time
+}
+
+func newKeyUsage() *keyUsage {
+	return &keyUsage{used: make(map[int64]time.Time)}
+}
+
+// record notes that the key was used just now.
+func (u *keyUsage) record(keyID int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.used[keyID] = time.Now()
+}
+
+// take returns the usage recorded since the last call and starts afresh.
+func (u *keyUsage) take() map[int64]time.Time {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	used := u.used
+	u.used = make(map[int64]time.Time)
+	return used
+}
+
+// flushKeyUsage periodically writes the recorded API key usage to the
+// database until done is closed, then writes whatever is left.
+func (app *application) flushKeyUsage(done <-chan struct{}) {
+	ticker := time.NewTicker(keyUsageFlushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		err := app.models.TrustedClientKeys.RecordUsage(context.Background(), app.keyUsage.take())
+		if err != nil {
+			app.logger.Error("unable to record API key usage", "error", err)
+		}
+	}
+
+	for {
+		select {
+		case <-done:
+			flush()
+			return
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
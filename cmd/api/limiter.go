@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shadyar-bakr/greenlight/internal/data"
+	"golang.org/x/time/rate"
+)
+
+// limit is a token bucket allowance: rps requests per second on average with
+// bursts of up to burst requests.
+type limit struct {
+	rps   float64
+	burst int
+}
+
+// routeGroups are the route groups that rate limit and concurrency policies
+// can be set for.
+var routeGroups = []string{"health", "auth", "read", "write", "admin"}
+
+// rateUnits are the units a limit's rate can be given in. A rate without a
+// unit is per second.
+var rateUnits = []struct {
+	suffix  string
+	seconds float64
+}{{"/s", 1}, {"/m", 60}, {"/h", 3600}}
+
+// parseLimit parses a limit written RATE:BURST, where RATE is requests per
+// second or a count per unit such as 30/m or 100/h.
+func parseLimit(val string) (limit, error) {
+	rateVal, burstVal, ok := strings.Cut(val, ":")
+	if !ok {
+		return limit{}, fmt.Errorf("invalid limit %q: want RATE:BURST", val)
+	}
+
+	var l limit
+	for _, unit := range rateUnits {
+		if n, found := strings.CutSuffix(rateVal, unit.suffix); found {
+			rateVal = n
+			l.rps = 1 / unit.seconds
+			break
+		}
+	}
+	if l.rps == 0 {
+		l.rps = 1
+	}
+
+	n, err := strconv.ParseFloat(rateVal, 64)
+	if err != nil || !(n > 0) || math.IsInf(n, 0) {
+		return limit{}, fmt.Errorf("invalid limit %q: rate must be a positive number", val)
+	}
+	l.rps *= n
+
+	l.burst, err = strconv.Atoi(burstVal)
+	if err != nil || l.burst < 1 {
+		return limit{}, fmt.Errorf("invalid limit %q: burst must be a positive integer", val)
+	}
+
+	return l, nil
+}
+
+// String formats l as parseLimit accepts it, using the largest unit that
+// gives a whole number of requests. The zero limit, meaning none, is empty.
+func (l limit) String() string {
+	if l.burst == 0 {
+		return ""
+	}
+
+	rate := strconv.FormatFloat(l.rps, 'g', -1, 64)
+	if l.rps != math.Trunc(l.rps) {
+		for _, unit := range rateUnits[1:] {
+			// Allow for rounding, as 3/m is stored as 0.05 per second.
+			if n := l.rps * unit.seconds; math.Abs(n-math.Round(n)) < 1e-9 {
+				rate = strconv.FormatFloat(math.Round(n), 'f', -1, 64) + unit.suffix
+				break
+			}
+		}
+	}
+
+	return rate + ":" + strconv.Itoa(l.burst)
+}
+
+// limitFlag is a flag.Value for a single limit. An empty value means no limit.
+type limitFlag limit
+
+func (f *limitFlag) String() string { return limit(*f).String() }
+
+func (f *limitFlag) Set(val string) error {
+	if val == "" {
+		*f = limitFlag{}
+		return nil
+	}
+
+	l, err := parseLimit(val)
+	*f = limitFlag(l)
+	return err
+}
+
+// limitSet is a flag.Value holding named limits written as space separated
+// NAME=RATE:BURST pairs.
+type limitSet map[string]limit
+
+func (s *limitSet) String() string {
+	var pairs []string
+	for _, name := range slices.Sorted(maps.Keys(*s)) {
+		pairs = append(pairs, name+"="+(*s)[name].String())
+	}
+	return strings.Join(pairs, " ")
+}
+
+func (s *limitSet) Set(val string) error {
+	set := make(limitSet)
+
+	for _, pair := range strings.Fields(val) {
+		name, limitVal, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid limit %q: want NAME=RATE:BURST", pair)
+		}
+
+		l, err := parseLimit(limitVal)
+		if err != nil {
+			return err
+		}
+		set[name] = l
+	}
+
+	*s = set
+	return nil
+}
+
+// countSet is a flag.Value holding named counts written as space separated
+// NAME=N pairs.
+type countSet map[string]int
+
+func (s *countSet) String() string {
+	var pairs []string
+	for _, name := range slices.Sorted(maps.Keys(*s)) {
+		pairs = append(pairs, name+"="+strconv.Itoa((*s)[name]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+func (s *countSet) Set(val string) error {
+	set := make(countSet)
+
+	for _, pair := range strings.Fields(val) {
+		name, countVal, ok := strings.Cut(pair, "=")
+		n, err := strconv.Atoi(countVal)
+		if !ok || name == "" || err != nil || n < 0 {
+			return fmt.Errorf("invalid count %q: want NAME=N", pair)
+		}
+		set[name] = n
+	}
+
+	*s = set
+	return nil
+}
+
+// limitResult is a limiter store's decision on a single request.
+type limitResult struct {
+	allowed bool
+	limit   limit
+	// remaining is the number of further requests allowed right now.
+	remaining int
+	// retryAfter is how long a rejected request should wait.
+	retryAfter time.Duration
+	// reset is how long until the full burst is available again.
+	reset time.Duration
+}
+
+// writeHeaders sets the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers from the IETF RateLimit header fields draft, plus
+// Retry-After when the request was rejected. Times are whole seconds, rounded
+// up so that a client waiting that long is never rejected for being early.
+// When several limiters pass a request, the headers describe the one closest
+// to rejecting it.
+func (res limitResult) writeHeaders(h http.Header) {
+	if res.allowed {
+		if current, err := strconv.Atoi(h.Get("RateLimit-Remaining")); err == nil && current < res.remaining {
+			return
+		}
+	}
+
+	h.Set("RateLimit-Limit", strconv.Itoa(res.limit.burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.reset)))
+
+	if !res.allowed {
+		h.Set("Retry-After", strconv.Itoa(max(ceilSeconds(res.retryAfter), 1)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// limiterStore holds rate limiter state. Keys identify who is being limited
+// and the limit is passed on every call, so a key picks up new limits, for
+// example after a reload, without the store being cleared.
+type limiterStore interface {
+	allow(ctx context.Context, key string, l limit) (limitResult, error)
+}
+
+// newLimiterStore returns the store selected by -limiter-store.
+func newLimiterStore(cfg config, models data.Models, logger *slog.Logger) (limiterStore, error) {
+	switch cfg.limiter.store {
+	case "memory":
+		return newMemoryLimiterStore(cfg.limiter.cleanup), nil
+	case "postgres":
+		return newPostgresLimiterStore(models.RateLimits, cfg.limiter.cleanup, logger), nil
+	default:
+		return nil, fmt.Errorf("invalid rate limiter store: %s", cfg.limiter.store)
+	}
+}
+
+// memoryLimiterStore keeps limiters in process. Each API instance enforces the
+// limits on its own and the state is lost on restart.
+type memoryLimiterStore struct {
+	mu      sync.Mutex
+	clients map[string]*memoryLimiter
+}
+
+type memoryLimiter struct {
+	limiter  *rate.Limiter
+	limit    limit
+	lastSeen time.Time
+}
+
+func newMemoryLimiterStore(cleanup time.Duration) *memoryLimiterStore {
+	s := &memoryLimiterStore{clients: make(map[string]*memoryLimiter)}
+
+	// Background cleanup using ticker
+	go func() {
+		ticker := time.NewTicker(cleanup)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.mu.Lock()
+			for key, client := range s.clients {
+				if time.Since(client.lastSeen) > cleanup {
+					delete(s.clients, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+
+	return s
+}
+
+func (s *memoryLimiterStore) allow(_ context.Context, key string, l limit) (limitResult, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, exists := s.clients[key]
+	if !exists || client.limit != l {
+		client = &memoryLimiter{
+			limiter: rate.NewLimiter(rate.Limit(l.rps), l.burst),
+			limit:   l,
+		}
+		s.clients[key] = client
+	}
+	client.lastSeen = now
+
+	result := limitResult{limit: l}
+
+	reservation := client.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		result.retryAfter = delay
+	} else {
+		result.allowed = true
+	}
+
+	tokens := client.limiter.TokensAt(now)
+	result.remaining = max(int(math.Floor(tokens)), 0)
+	result.reset = time.Duration((float64(l.burst) - tokens) / l.rps * float64(time.Second))
+
+	return result, nil
+}
+
+// postgresLimiterStore keeps limiter state in the rate_limits table so that
+// every API instance shares one allowance per key and limits survive deploys.
+type postgresLimiterStore struct {
+	model data.RateLimitModel
+}
+
+func newPostgresLimiterStore(model data.RateLimitModel, cleanup time.Duration, logger *slog.Logger) *postgresLimiterStore {
+	s := &postgresLimiterStore{model: model}
+
+	// Delete rows for keys that have their full burst back. Every instance
+	// runs this, which is harmless as the delete is idempotent.
+	go func() {
+		ticker := time.NewTicker(cleanup)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			_, err := s.model.DeleteExpired(context.Background())
+			if err != nil {
+				logger.Error("unable to delete expired rate limits", "error", err)
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *postgresLimiterStore) allow(ctx context.Context, key string, l limit) (limitResult, error) {
+	emission := time.Duration(float64(time.Second) / l.rps)
+	tolerance := emission * time.Duration(l.burst)
+
+	allowed, ahead, err := s.model.Take(ctx, key, emission, tolerance)
+	if err != nil {
+		return limitResult{}, err
+	}
+
+	result := limitResult{
+		allowed: allowed,
+		limit:   l,
+		reset:   max(ahead, 0),
+	}
+
+	if allowed {
+		result.remaining = max(int((tolerance-ahead)/emission), 0)
+	} else {
+		result.retryAfter = max(ahead+emission-tolerance, 0)
+	}
+
+	return result, nil
+}
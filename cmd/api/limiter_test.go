@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shadyar-bakr/greenlight/internal/data"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    limit
+		wantErr bool
+	}{
+		{val: "2:4", want: limit{rps: 2, burst: 4}},
+		{val: "0.5:5", want: limit{rps: 0.5, burst: 5}},
+		{val: "10/s:20", want: limit{rps: 10, burst: 20}},
+		{val: "30/m:5", want: limit{rps: 0.5, burst: 5}},
+		{val: "3/m:1", want: limit{rps: 0.05, burst: 1}},
+		{val: "100/h:10", want: limit{rps: 100.0 / 3600, burst: 10}},
+		{val: "1.5/m:2", want: limit{rps: 0.025, burst: 2}},
+		{val: "2", wantErr: true},
+		{val: ":4", wantErr: true},
+		{val: "2:", wantErr: true},
+		{val: "0:4", wantErr: true},
+		{val: "-1:4", wantErr: true},
+		{val: "Inf:4", wantErr: true},
+		{val: "NaN:4", wantErr: true},
+		{val: "2/d:4", wantErr: true},
+		{val: "2:0", wantErr: true},
+		{val: "2:1.5", wantErr: true},
+		{val: "/m:4", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseLimit(tt.val)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseLimit(%q) = %+v; want an error", tt.val, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseLimit(%q) error = %v", tt.val, err)
+			continue
+		}
+		if math.Abs(got.rps-tt.want.rps) > 1e-12 || got.burst != tt.want.burst {
+			t.Errorf("parseLimit(%q) = %+v; want %+v", tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestLimitString(t *testing.T) {
+	tests := []struct {
+		l    limit
+		want string
+	}{
+		{l: limit{}, want: ""},
+		{l: limit{rps: 2, burst: 4}, want: "2:4"},
+		{l: limit{rps: 0.5, burst: 5}, want: "30/m:5"},
+		{l: limit{rps: 0.05, burst: 1}, want: "3/m:1"},
+		{l: limit{rps: 1.0 / 3, burst: 2}, want: "20/m:2"},
+		{l: limit{rps: 100.0 / 3600, burst: 10}, want: "100/h:10"},
+		{l: limit{rps: 1.0 / 3600, burst: 1}, want: "1/h:1"},
+		{l: limit{rps: 2.0 / 7, burst: 3}, want: "0.2857142857142857:3"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.l.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q; want %q", tt.l, got, tt.want)
+		}
+	}
+}
+
+func TestLimitRoundTrip(t *testing.T) {
+	for _, val := range []string{"2:4", "30/m:5", "3/m:1", "20/m:2", "100/h:10", "7/h:1", "0.25:1"} {
+		l, err := parseLimit(val)
+		if err != nil {
+			t.Fatalf("parseLimit(%q) error = %v", val, err)
+		}
+
+		again, err := parseLimit(l.String())
+		if err != nil {
+			t.Fatalf("parseLimit(%q) error = %v", l.String(), err)
+		}
+		if math.Abs(again.rps-l.rps) > 1e-12 || again.burst != l.burst {
+			t.Errorf("%q became %+v after formatting as %q; want %+v", val, again, l.String(), l)
+		}
+	}
+}
+
+func TestGroupLimitsAreNotCappedByTheIPLimit(t *testing.T) {
+	cfg, _, err := loadConfig([]string{"-limiter-rps=2", "-limiter-burst=4", "-limiter-groups=read=10:20", "-limiter-roles="})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		config:      cfg,
+		logger:      discardLogger(),
+		instruments: newInstruments(nil),
+		limiter:     newMemoryLimiterStore(time.Minute),
+		roleCache:   newRoleCache(),
+	}
+	app.settings.Store(newSettings(cfg))
+
+	// Stand in for authenticate: the user ID comes from a header.
+	authenticate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := data.AnonymousUser
+			if id, err := strconv.ParseInt(r.Header.Get("X-User"), 10, 64); err == nil {
+				user = &data.User{ID: id, Activated: true}
+			}
+			next.ServeHTTP(w, app.contextSetUser(r, user))
+		})
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := chi.NewRouter()
+	r.Use(app.clientIP, app.rateLimit, authenticate)
+	r.Group(func(r chi.Router) {
+		r.Use(app.rateLimitGroup("read"))
+		r.Get("/v1/movies", ok)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(app.rateLimitGroup("health"))
+		r.Get("/health", ok)
+	})
+
+	// burst sends requests from one IP until one is refused, returning how
+	// many got through.
+	burst := func(path, user string) int {
+		for n := 0; n < 100; n++ {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.RemoteAddr = "198.51.100.1:1234"
+			if user != "" {
+				req.Header.Set("X-User", user)
+			}
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code == http.StatusTooManyRequests {
+				return n
+			}
+		}
+		return 100
+	}
+
+	tests := []struct {
+		name, path, user string
+		want             int
+	}{
+		{name: "user in a group with a policy", path: "/v1/movies", user: "1", want: 20},
+		{name: "another user behind the same IP", path: "/v1/movies", user: "2", want: 20},
+		{name: "anonymous in a group with a policy", path: "/v1/movies", want: 20},
+		{name: "group without a policy", path: "/health", want: 4},
+		// The IP's default allowance is already used up by /health.
+		{name: "unmatched route", path: "/nothing", want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := burst(tt.path, tt.user); got != tt.want {
+			t.Errorf("%s: %d requests allowed; want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shadyar-bakr/greenlight/internal/data"
+	"github.com/shadyar-bakr/greenlight/internal/jobs"
+	"github.com/shadyar-bakr/greenlight/internal/logging"
+	"github.com/shadyar-bakr/greenlight/internal/validator"
+)
+
+// loginFailed records a failed login and sends the response for it. user is
+// nil when the email didn't match an account. Once an account reaches the
+// lockout threshold it is locked and its owner emailed an unlock link;
+// otherwise the account and the client's IP must wait before trying again, a
+// little longer with every recent failure against either.
+func (app *application) loginFailed(w http.ResponseWriter, r *http.Request, user *data.User) {
+	ip := app.loginFailureIP(r)
+
+	var userID int64
+	if user != nil {
+		userID = user.ID
+	}
+
+	// There's nothing to hold the failure against.
+	if userID == 0 && ip == "" {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	userFailures, ipFailures, err := app.models.Lockouts.CountFailures(r.Context(), userID, ip, time.Now().Add(-app.config.lockout.window))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Count this failure along with the recent ones.
+	if user != nil {
+		userFailures++
+	}
+	if ip != "" {
+		ipFailures++
+	}
+
+	var nextAttempt time.Time
+	if app.config.lockout.delay > 0 {
+		nextAttempt = time.Now().Add(failedLoginDelay(app.config.lockout.delay, app.config.lockout.maxDelay, max(userFailures, ipFailures)))
+	}
+
+	err = app.models.Lockouts.RecordFailure(r.Context(), userID, ip, nextAttempt)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil && userFailures >= app.config.lockout.threshold {
+		until := time.Now().Add(app.config.lockout.duration)
+
+		err = app.lockAccount(r.Context(), user, userFailures, until)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.accountLockedResponse(w, r, until)
+		return
+	}
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+// loginFailureIP returns the client's IP as failed logins are recorded
+// against it, or an empty string if it couldn't be determined.
+func (app *application) loginFailureIP(r *http.Request) string {
+	ip := app.contextGetClientIP(r)
+	if !ip.IsValid() {
+		return ""
+	}
+
+	return ip.String()
+}
+
+// loginTooSoon reports whether the account or the client's IP is trying to
+// log in again sooner than allowed after a failed login, in which case the
+// attempt is refused with a 429. userID is zero when the account isn't known.
+func (app *application) loginTooSoon(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	if app.config.lockout.delay <= 0 {
+		return false
+	}
+
+	next, err := app.models.Lockouts.NextAttempt(r.Context(), userID, app.loginFailureIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+
+	if next.IsZero() {
+		return false
+	}
+
+	app.instruments.rateLimitRejections.WithLabelValues("login_delay").Inc()
+
+	w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(time.Until(next)), 1)))
+	app.rateLimitExceededResponse(w, r)
+	return true
+}
+
+// failedLoginDelay returns base doubled for every failure after the first,
+// capped at maxDelay. It stops doubling once the cap is reached so that the
+// delay can't overflow however many failures there have been.
+func failedLoginDelay(base, maxDelay time.Duration, failures int) time.Duration {
+	delay := min(base, maxDelay)
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		if delay > maxDelay/2 {
+			return maxDelay
+		}
+		delay *= 2
+	}
+	return delay
+}
+
+// lockAccount locks the account and, in the same transaction, queues an email
+// with a token that unlocks it early.
+func (app *application) lockAccount(ctx context.Context, user *data.User, failures int, until time.Time) error {
+	logging.FromContext(ctx).WarnContext(ctx, "locking account after failed logins", "user_id", user.ID, "failures", failures, "locked_until", until)
+
+	return app.models.Transaction(ctx, func(tx data.Models) error {
+		err := tx.Lockouts.Lock(ctx, user.ID, failures, until)
+		if err != nil {
+			return err
+		}
+
+		// Start counting afresh once the lockout ends.
+		err = tx.Lockouts.ClearFailures(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllForUser(ctx, data.ScopeUnlock, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err := tx.Tokens.New(ctx, user.ID, time.Until(until), data.ScopeUnlock)
+		if err != nil {
+			return err
+		}
+
+		job, err := jobs.NewJob(jobSendEmail, sendEmailPayload{
+			Recipient: user.Email,
+			Locale:    user.Locale,
+			Template:  "account_locked.tmpl",
+			Data: map[string]any{
+				"unlockToken": token.Plaintext,
+				"lockedUntil": until.UTC().Format(time.RFC1123),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Jobs.Insert(ctx, job)
+	})
+}
+
+// tooManyLoginFailures reports whether the client's IP has failed to log in
+// too often recently, in which case the attempt is refused with a 429.
+func (app *application) tooManyLoginFailures(w http.ResponseWriter, r *http.Request) bool {
+	ip := app.loginFailureIP(r)
+	if app.config.lockout.ipThreshold == 0 || ip == "" {
+		return false
+	}
+
+	_, ipFailures, err := app.models.Lockouts.CountFailures(r.Context(), 0, ip, time.Now().Add(-app.config.lockout.window))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+
+	if ipFailures < app.config.lockout.ipThreshold {
+		return false
+	}
+
+	logging.FromContext(r.Context()).WarnContext(r.Context(), "refusing login from IP after failed logins", "client_ip", ip, "failures", ipFailures)
+	app.instruments.rateLimitRejections.WithLabelValues("login_failures").Inc()
+
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(app.config.lockout.window)))
+	app.rateLimitExceededResponse(w, r)
+	return true
+}
+
+// purgeLoginFailures deletes failed logins that are past the lockout window
+// until done is closed.
+func (app *application) purgeLoginFailures(done <-chan struct{}) {
+	ticker := time.NewTicker(app.config.lockout.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_, err := app.models.Lockouts.DeleteFailuresBefore(context.Background(), time.Now().Add(-app.config.lockout.window))
+			if err != nil {
+				app.logger.Error("unable to delete old login failures", "error", err)
+			}
+		}
+	}
+}
+
+func (app *application) unlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeUnlock, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired unlock token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The lockout may have expired or been cleared since the email was sent,
+	// which is fine: either way the account ends up unlocked.
+	err = app.models.Lockouts.Unlock(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeUnlock, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account unlocked successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := app.models.Lockouts.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"lockouts": lockouts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteLockoutHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Lockouts.Unlock(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeUnlock, userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account unlocked successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shadyar-bakr/greenlight/internal/data"
+)
+
+func TestFailedLoginDelay(t *testing.T) {
+	tests := []struct {
+		base     time.Duration
+		maxDelay time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{base: 250 * time.Millisecond, maxDelay: 5 * time.Second, failures: 1, want: 250 * time.Millisecond},
+		{base: 250 * time.Millisecond, maxDelay: 5 * time.Second, failures: 2, want: 500 * time.Millisecond},
+		{base: 250 * time.Millisecond, maxDelay: 5 * time.Second, failures: 5, want: 4 * time.Second},
+		{base: 250 * time.Millisecond, maxDelay: 5 * time.Second, failures: 6, want: 5 * time.Second},
+		{base: 250 * time.Millisecond, maxDelay: 5 * time.Second, failures: 1000, want: 5 * time.Second},
+		{base: 10 * time.Second, maxDelay: time.Hour, failures: 33, want: time.Hour},
+		{base: 10 * time.Second, maxDelay: time.Hour, failures: math.MaxInt, want: time.Hour},
+		{base: time.Second, maxDelay: math.MaxInt64, failures: 100, want: math.MaxInt64},
+		{base: 5 * time.Second, maxDelay: 5 * time.Second, failures: 3, want: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		got := failedLoginDelay(tt.base, tt.maxDelay, tt.failures)
+		if got != tt.want {
+			t.Errorf("failedLoginDelay(%s, %s, %d) = %s; want %s", tt.base, tt.maxDelay, tt.failures, got, tt.want)
+		}
+	}
+}
+
+// nextAttemptDB is a data.DBTX whose queries find the given next attempt time
+// and which records the arguments of the last query.
+type nextAttemptDB struct {
+	data.DBTX
+	next time.Time
+	args []any
+}
+
+func (db *nextAttemptDB) QueryRow(_ context.Context, _ string, args ...any) pgx.Row {
+	db.args = args
+	return nextAttemptRow{db.next}
+}
+
+type nextAttemptRow struct{ next time.Time }
+
+func (row nextAttemptRow) Scan(dest ...any) error {
+	*dest[0].(**time.Time) = &row.next
+	return nil
+}
+
+func TestLoginTooSoon(t *testing.T) {
+	cfg, _, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &nextAttemptDB{next: time.Now().Add(1500 * time.Millisecond)}
+	app := &application{
+		config:      cfg,
+		logger:      discardLogger(),
+		instruments: newInstruments(nil),
+		models:      data.Models{Lockouts: data.LockoutModel{DB: db}},
+	}
+
+	tests := []struct {
+		name   string
+		ip     netip.Addr
+		wantIP string
+	}{
+		{name: "known IP", ip: netip.MustParseAddr("203.0.113.7"), wantIP: "203.0.113.7"},
+		{name: "unknown IP", ip: netip.Addr{}, wantIP: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := app.contextSetClientIP(httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", nil), tt.ip)
+			rr := httptest.NewRecorder()
+
+			if !app.loginTooSoon(rr, r, 1) {
+				t.Fatal("got an attempt allowed before its next attempt time")
+			}
+			if rr.Code != http.StatusTooManyRequests {
+				t.Errorf("got status %d; want %d", rr.Code, http.StatusTooManyRequests)
+			}
+			if got := rr.Header().Get("Retry-After"); got != "2" {
+				t.Errorf("got Retry-After %q; want 2", got)
+			}
+			if db.args[1] != tt.wantIP {
+				t.Errorf("checked IP %q; want %q", db.args[1], tt.wantIP)
+			}
+		})
+	}
+}
+
+func TestLoginFailedWithoutAccountOrClientIP(t *testing.T) {
+	cfg, _, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &countingDB{}
+	app := &application{
+		config:      cfg,
+		logger:      discardLogger(),
+		instruments: newInstruments(nil),
+		models:      data.Models{Lockouts: data.LockoutModel{DB: db}},
+	}
+
+	r := app.contextSetClientIP(httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", nil), netip.Addr{})
+	rr := httptest.NewRecorder()
+	app.loginFailed(rr, r, nil)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if n := db.queries.Load(); n != 0 {
+		t.Errorf("made %d queries; want 0", n)
+	}
+}
@@ -9,108 +9,116 @@ import (
 	"log/slog"
 	"os"
 	"runtime"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/joho/godotenv"
 	"github.com/shadyar-bakr/greenlight/internal/data"
+	"github.com/shadyar-bakr/greenlight/internal/jobs"
+	"github.com/shadyar-bakr/greenlight/internal/logging"
 	"github.com/shadyar-bakr/greenlight/internal/mailer"
 	"github.com/shadyar-bakr/greenlight/internal/vcs"
+	"github.com/shadyar-bakr/greenlight/migrations"
 )
 
 var version = vcs.Version()
 
-type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
-	}
-	limiter struct {
-		rps     float64
-		burst   int
-		cleanup time.Duration
-		enabled bool
-	}
-	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
-	}
-	cors struct {
-		trustedOrigins []string
-	}
-}
-
 type application struct {
 	config config
-	logger *slog.Logger
-	models data.Models
-	mailer *mailer.Mailer
-	wg     sync.WaitGroup
+	// configValues holds the value of every setting as last applied, for
+	// reporting what a reload changes.
+	configValues map[string]string
+	// settings and logLevel are the parts of config that can be reloaded.
+	settings atomic.Pointer[settings]
+	logLevel *slog.LevelVar
+	logger   *slog.Logger
+	db       *pgxpool.Pool
+	models   data.Models
+	// schemaVersion is the version of the newest embedded migration.
+	schemaVersion int
+	mailer        *mailer.Mailer
+	queue         *jobs.Queue
+	instruments   *instruments
+	limiter       limiterStore
+	keyUsage      *keyUsage
+	roleCache     *roleCache
+	wg            sync.WaitGroup
+
+	// draining is set once shutdown begins so that the readiness endpoint
+	// starts failing while in-flight requests complete.
+	draining atomic.Bool
 }
 
 func main() {
-	err := godotenv.Load()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := runMigrateCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	cfg, flags, err := loadConfig(os.Args[1:])
 	if err != nil {
-		fmt.Printf("Error loading .env file: %v\n", err)
-		os.Exit(1)
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Println(err)
+		os.Exit(2)
 	}
 
-	var cfg config
-
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PostgreSQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max idle time")
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.DurationVar(&cfg.limiter.cleanup, "limiter-cleanup", 3*time.Minute, "Rate limiter cleanup time")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("SMTP_HOST"), "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("SMTP_USERNAME"), "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", os.Getenv("SMTP_SENDER"), "SMTP sender")
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
-		cfg.cors.trustedOrigins = strings.Fields(val)
-		return nil
-	})
-
-	displayVersion := flag.Bool("version", false, "Display version and exit")
-
-	flag.Parse()
-
-	if *displayVersion {
+	if flags.Lookup("version").Value.String() == "true" {
 		fmt.Printf("Version: %s\n", version)
 		os.Exit(0)
 	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelDebug,
-		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
-			if a.Key == slog.TimeKey {
-				return slog.Attr{}
-			}
-			return a
-		},
-		AddSource: true,
-	}))
+	printOnly := flags.Lookup("print-config").Value.String() == "true"
+	if printOnly {
+		printConfig(os.Stdout, flags, cfg.sources)
+	}
 
-	if err := cfg.validate(); err != nil {
-		logger.Error("invalid configuration", "error", err)
+	err = cfg.validate()
+	if err != nil {
+		fmt.Printf("invalid configuration:\n%v\n", err)
 		os.Exit(1)
 	}
 
+	if printOnly {
+		os.Exit(0)
+	}
+
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.log.level)
+
+	logger, err := logging.New(os.Stdout, cfg.log.format, logLevel, cfg.log.source)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	for name, reason := range deprecatedFlags {
+		if source := cfg.sources[name]; source != "default" {
+			logger.Warn("ignoring deprecated setting", "setting", name, "source", source, "reason", reason)
+		}
+	}
+
+	shutdownTracing, err := setupTracing(cfg)
+	if err != nil {
+		logger.Error("unable to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("unable to flush traces", "error", err)
+		}
+	}()
+
 	db, err := openDB(cfg, logger)
 	if err != nil {
 		logger.Error("unable to connect to database", "error", err)
@@ -118,15 +126,38 @@ func main() {
 	}
 	defer db.Close()
 
-	mailer, err := mailer.New(mailer.SMTPConfig{
-		Host:     cfg.smtp.host,
-		Port:     cfg.smtp.port,
-		Username: cfg.smtp.username,
-		Password: cfg.smtp.password,
-		Sender:   cfg.smtp.sender,
-	})
+	schemaVersion, err := migrations.Latest()
+	if err != nil {
+		logger.Error("unable to read embedded migrations", "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.db.autoMigrate {
+		err = migrateDB(context.Background(), db, logger)
+		if err != nil {
+			logger.Error("unable to apply migrations", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	models := data.NewModels(db, cfg.db.queryTimeout)
+
+	// Refuse to serve against a schema the code wasn't written for.
+	err = checkSchemaVersion(context.Background(), models.Schema, schemaVersion)
+	if err != nil {
+		logger.Error("schema version mismatch", "error", err)
+		os.Exit(1)
+	}
+
+	transport, err := newMailTransport(cfg, logger)
+	if err != nil {
+		logger.Error("unable to create mail transport", "error", err)
+		os.Exit(1)
+	}
+
+	limiter, err := newLimiterStore(cfg, models, logger)
 	if err != nil {
-		logger.Error("unable to create mailer", "error", err)
+		logger.Error("unable to create rate limiter store", "error", err)
 		os.Exit(1)
 	}
 
@@ -152,12 +183,33 @@ func main() {
 	}))
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer,
+		config:        cfg,
+		configValues:  flagValues(flags),
+		logLevel:      logLevel,
+		logger:        logger,
+		db:            db,
+		models:        models,
+		schemaVersion: schemaVersion,
+		instruments:   newInstruments(db),
+		limiter:       limiter,
+		keyUsage:      newKeyUsage(),
+		roleCache:     newRoleCache(),
+		mailer: mailer.New(transport, mailer.Config{
+			Sender:      cfg.smtp.sender,
+			BaseURL:     cfg.mail.baseURL,
+			TemplateDir: cfg.mail.templateDir,
+		}),
+		queue: jobs.New(models.Jobs, logger, jobs.Config{
+			Workers:      cfg.jobs.workers,
+			PollInterval: cfg.jobs.pollInterval,
+			LockTimeout:  cfg.jobs.lockTimeout,
+			MaxBackoff:   cfg.jobs.maxBackoff,
+		}),
 	}
 
+	app.settings.Store(newSettings(cfg))
+	app.registerJobHandlers()
+
 	err = app.serve()
 	if err != nil {
 		logger.Error(err.Error())
@@ -171,6 +223,13 @@ func openDB(cfg config, logger *slog.Logger) (*pgxpool.Pool, error) {
 		return nil, err
 	}
 
+	poolConfig.MaxConns = int32(cfg.db.maxOpenConns)
+	poolConfig.MinConns = int32(cfg.db.minConns)
+	poolConfig.MaxConnIdleTime = cfg.db.maxIdleTime
+	poolConfig.MaxConnLifetime = cfg.db.maxConnLifetime
+	poolConfig.HealthCheckPeriod = cfg.db.healthCheckPeriod
+	poolConfig.ConnConfig.Tracer = data.NewQueryTracer()
+
 	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
 		return nil, err
@@ -186,38 +245,20 @@ func openDB(cfg config, logger *slog.Logger) (*pgxpool.Pool, error) {
 	return pool, nil
 }
 
-func (cfg *config) validate() error {
-	if cfg.port < 1 || cfg.port > 65535 {
-		return fmt.Errorf("invalid port number: %d", cfg.port)
+func newMailTransport(cfg config, logger *slog.Logger) (mailer.Transport, error) {
+	switch cfg.mail.transport {
+	case "file":
+		return mailer.NewFileTransport(cfg.mail.dir)
+	case "memory":
+		return mailer.NewMemoryTransport(), nil
+	case "log":
+		return mailer.NewLogTransport(logger), nil
+	default:
+		return mailer.NewSMTPTransport(mailer.SMTPConfig{
+			Host:     cfg.smtp.host,
+			Port:     cfg.smtp.port,
+			Username: cfg.smtp.username,
+			Password: cfg.smtp.password,
+		}), nil
 	}
-
-	if cfg.env != "development" && cfg.env != "staging" && cfg.env != "production" {
-		return fmt.Errorf("invalid environment: %s", cfg.env)
-	}
-
-	if cfg.db.dsn == "" {
-		return errors.New("database DSN is required")
-	}
-
-	if cfg.smtp.host == "" {
-		return errors.New("SMTP host is required")
-	}
-
-	if cfg.smtp.port < 1 || cfg.smtp.port > 65535 {
-		return fmt.Errorf("invalid SMTP port number: %d", cfg.smtp.port)
-	}
-
-	if cfg.smtp.username == "" {
-		return errors.New("SMTP username is required")
-	}
-
-	if cfg.smtp.password == "" {
-		return errors.New("SMTP password is required")
-	}
-
-	if cfg.smtp.sender == "" {
-		return errors.New("SMTP sender is required")
-	}
-
-	return nil
 }
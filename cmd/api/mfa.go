@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/shadyar-bakr/greenlight/internal/data"
+	"github.com/shadyar-bakr/greenlight/internal/totp"
+	"github.com/shadyar-bakr/greenlight/internal/validator"
+)
+
+const (
+	// totpIssuer is the name authenticator apps show next to the account.
+	totpIssuer = "Greenlight"
+	// totpSkew is how many steps either side of the current one are accepted,
+	// to allow for the clock on the user's device being slightly off.
+	totpSkew = 1
+	// mfaTokenTTL is how long a user has to enter their code after giving the
+	// right password.
+	mfaTokenTTL = 5 * time.Minute
+)
+
+// checkTOTP reports whether code is a valid TOTP code for the enrollment and,
+// if so, records it as used so that it can't be replayed. Pass the models of
+// a transaction to record it together with other changes.
+func (app *application) checkTOTP(ctx context.Context, models data.Models, enrollment *data.TOTP, code string) (bool, error) {
+	step, ok, err := totp.Validate(enrollment.Secret, code, time.Now(), totpSkew)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	err = models.MFA.UseTOTPStep(ctx, enrollment.UserID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrCodeReused):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// requireTOTPCode reads a TOTP code from the request body and checks it
+// against the user's confirmed enrollment, sending an error response and
+// returning false if that fails. It guards the endpoints that change an
+// existing second factor, so a stolen access token alone can't.
+func (app *application) requireTOTPCode(w http.ResponseWriter, r *http.Request, user *data.User) bool {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return false
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	enrollment, err := app.models.MFA.GetTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if enrollment == nil || !enrollment.Confirmed {
+		v.AddError("totp", "two-factor authentication is not enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	ok, err := app.checkTOTP(r.Context(), app.models, enrollment, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !ok {
+		v.AddError("code", "invalid or already used code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	return true
+}
+
+// enrollTOTPHandler generates a new TOTP secret for the user. It doesn't
+// protect logins until confirmed with a code from the authenticator app.
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.CreateTOTP(r.Context(), user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAAlreadyEnabled):
+			v := validator.New()
+			v.AddError("totp", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{
+		"secret":           secret,
+		"provisioning_uri": totp.URI(totpIssuer, user.Email, secret),
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTOTPHandler turns on two-factor authentication once the user proves
+// their authenticator app is set up, and returns their recovery codes.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	enrollment, err := app.models.MFA.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("totp", "must be enrolled first")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if enrollment.Confirmed {
+		v.AddError("totp", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Confirm the enrollment and issue the recovery codes together, so that
+	// two-factor authentication is never on without a way to recover.
+	var (
+		ok    bool
+		codes []string
+	)
+
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		ok, err = app.checkTOTP(r.Context(), tx, enrollment, input.Code)
+		if err != nil || !ok {
+			return err
+		}
+
+		codes, err = tx.MFA.NewRecoveryCodes(r.Context(), user.ID)
+		return err
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		v.AddError("code", "invalid or already used code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTOTPHandler turns two-factor authentication off, which takes a
+// current code.
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	if !app.requireTOTPCode(w, r, user) {
+		return
+	}
+
+	err := app.models.MFA.DeleteTOTP(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// regenerateRecoveryCodesHandler replaces the user's recovery codes, for
+// example once most have been used up. It takes a current code.
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	if !app.requireTOTPCode(w, r, user) {
+		return
+	}
+
+	codes, err := app.models.MFA.NewRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMFATokenHandler exchanges the mfa-pending token from a password login
+// and a TOTP or recovery code for an access/refresh token pair. Wrong codes
+// count towards the account lockout just like wrong passwords.
+func (app *application) createMFATokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, input.MFAToken)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "a code or recovery code must be provided")
+	v.Check(input.Code == "" || input.RecoveryCode == "", "code", "must not be provided together with a recovery code")
+	if input.Code != "" {
+		data.ValidateTOTPCode(v, input.Code)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if app.tooManyLoginFailures(w, r) {
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeMFA, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("mfa_token", "invalid or expired mfa token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.allowLogin(w, r, user.Email) || app.loginTooSoon(w, r, user.ID) {
+		return
+	}
+
+	// The account may have been locked by failed codes since the password was
+	// accepted.
+	lockout, err := app.models.Lockouts.GetForUser(r.Context(), user.ID)
+	switch {
+	case err == nil:
+		app.accountLockedResponse(w, r, lockout.LockedUntil)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var ok bool
+
+	if input.RecoveryCode != "" {
+		err = app.models.MFA.UseRecoveryCode(r.Context(), user.ID, input.RecoveryCode)
+		switch {
+		case err == nil:
+			ok = true
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		enrollment, err := app.models.MFA.GetTOTP(r.Context(), user.ID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Two-factor authentication may have been turned off in the meantime,
+		// in which case no code is valid and the user logs in again.
+		if enrollment != nil && enrollment.Confirmed {
+			ok, err = app.checkTOTP(r.Context(), app.models, enrollment, input.Code)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+
+	if !ok {
+		app.loginFailed(w, r, user)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeMFA, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Lockouts.ClearFailures(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	accessToken, refreshToken, err := app.models.Tokens.NewPair(r.Context(), user.ID, 15*time.Minute, 24*time.Hour)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
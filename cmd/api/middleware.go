@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"errors"
-	"expvar"
+	"fmt"
+	"log/slog"
+	"mime"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -12,16 +15,20 @@ import (
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/shadyar-bakr/greenlight/internal/data"
+	"github.com/shadyar-bakr/greenlight/internal/logging"
 	"github.com/shadyar-bakr/greenlight/internal/validator"
-	"golang.org/x/time/rate"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const (
-	traceIDContextKey    = contextKey("trace_id")
-	startTimeContextKey  = contextKey("start_time")
-	userAgentContextKey  = contextKey("user_agent")
-	remoteAddrContextKey = contextKey("remote_addr")
-)
+// supportedMediaTypes are the request and response body formats the API
+// understands. Everything is JSON apart from the bulk movie import and export
+// endpoints, which also speak NDJSON and CSV, and the OAuth endpoints, which
+// take form-encoded requests as RFC 6749 requires.
+var supportedMediaTypes = []string{"application/json", "application/x-ndjson", "text/csv", "application/x-www-form-urlencoded"}
 
 type permissionCache struct {
 	permissions data.Permissions
@@ -29,168 +36,276 @@ type permissionCache struct {
 }
 
 func (app *application) metrics(next http.Handler) http.Handler {
-	var (
-		totalRequestsReceived           = expvar.NewInt("total_requests_received")
-		totalResponsesSent              = expvar.NewInt("total_responses_sent")
-		totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
-		totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
-		totalRequestsByPath             = expvar.NewMap("total_requests_by_path")
-		totalRequestsByMethod           = expvar.NewMap("total_requests_by_method")
-		activeConnections               = expvar.NewInt("active_connections")
-		totalResponseSize               = expvar.NewInt("total_response_size_bytes")
-		averageResponseTime             = expvar.NewFloat("average_response_time_ms")
-		requestCount                    = expvar.NewInt("request_count")
-	)
-
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		activeConnections.Add(1)
-		defer activeConnections.Add(-1)
-
-		// Record request metrics
-		totalRequestsReceived.Add(1)
-		totalRequestsByPath.Add(r.URL.Path, 1)
-		totalRequestsByMethod.Add(r.Method, 1)
+		app.instruments.requestsInFlight.Inc()
+		defer app.instruments.requestsInFlight.Dec()
 
 		// Use Chi's middleware.WrapResponseWriter with additional tracking
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 
 		next.ServeHTTP(ww, r)
 
-		// Record response metrics
-		totalResponsesSent.Add(1)
-		totalResponsesSentByStatus.Add(strconv.Itoa(ww.Status()), 1)
-		totalResponseSize.Add(int64(ww.BytesWritten()))
+		// Label by route pattern rather than raw path so that the number
+		// of series stays bounded (/v1/movies/{id}, not /v1/movies/1).
+		route := routePattern(r)
 
-		// Calculate and update timing metrics
 		duration := time.Since(start)
-		durationMS := float64(duration) / float64(time.Millisecond)
-		totalProcessingTimeMicroseconds.Add(duration.Microseconds())
-
-		// Update average response time
-		oldCount := requestCount.Value()
-		requestCount.Add(1)
-		newAvg := (averageResponseTime.Value()*float64(oldCount) + durationMS) / float64(oldCount+1)
-		averageResponseTime.Set(newAvg)
-
-		// Log request details with additional information
-		app.logger.Info("request completed",
-			"method", r.Method,
-			"path", r.URL.Path,
-			"status", ww.Status(),
-			"duration_ms", durationMS,
-			"size_bytes", ww.BytesWritten(),
-			"request_id", middleware.GetReqID(r.Context()),
-			"client_ip", r.RemoteAddr,
-			"user_agent", r.UserAgent(),
-		)
+		app.instruments.requestDuration.
+			WithLabelValues(route, r.Method, strconv.Itoa(ww.Status())).
+			Observe(duration.Seconds())
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
+// logClientRequest records requests made by trusted clients, with the status
+// they were answered with and how long that took, for the usage API. It comes
+// before rateLimit and authenticate, which note the client once they know it,
+// so that requests they reject are recorded too.
+func (app *application) logClientRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, clientID := app.contextTrackClient(r)
 
-	var (
-		mu      sync.RWMutex
-		clients = make(map[string]*client)
-	)
-
-	// Background cleanup using ticker
-	go func() {
-		ticker := time.NewTicker(app.config.limiter.cleanup)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			mu.Lock()
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > app.config.limiter.cleanup {
-					delete(clients, ip)
-				}
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		id := clientID.Load()
+		if id == 0 {
+			return
+		}
+
+		duration := time.Since(start)
+
+		route := routePattern(r)
+
+		// A handler that never writes anything implicitly sends a 200.
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		app.background(func() {
+			err := app.models.TrustedClients.LogRequest(context.Background(), id, route, r.Method, status, duration)
+			if err != nil {
+				app.logger.Error("unable to log trusted client request", "client_id", id, "error", err)
 			}
-			mu.Unlock()
+		})
+	})
+}
+
+// logRequest writes one access log entry per request and stores a
+// request-scoped logger in the context. Middleware and handlers further down
+// add to it with logging.With, so the entry also carries the user and route.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		logger := app.logger.With("request_id", middleware.GetReqID(r.Context()))
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			logger = logger.With("trace_id", sc.TraceID().String())
 		}
-	}()
 
+		ctx := logging.NewContext(r.Context(), logger)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		logging.With(ctx, "route", routePattern(r))
+
+		logging.FromContext(ctx).LogAttrs(ctx, slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", ww.Status()),
+			slog.Float64("duration_ms", float64(time.Since(start))/float64(time.Millisecond)),
+			slog.Int("size_bytes", ww.BytesWritten()),
+			slog.String("client_ip", app.contextGetClientIP(r).String()),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
+
+// rateLimit applies the per-client limits of trusted clients using an API
+// key. Requests to routes in a group are otherwise left to rateLimitGroup, so
+// that the group, user and role policies decide; requests that match no route
+// are limited per IP at -limiter-rps and -limiter-burst.
+func (app *application) rateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !app.config.limiter.enabled {
+		s := app.settings.Load()
+		if !s.limiter.enabled {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		// Check for API key in header
-		apiKey := r.Header.Get("X-API-Key")
-		if apiKey != "" {
-			trustedClient, err := app.models.TrustedClients.GetByAPIKey(apiKey)
-			if err == nil && trustedClient.Enabled {
-				// Use client-specific rate limits
-				mu.RLock()
-				clientInfo, exists := clients[apiKey]
-				mu.RUnlock()
-
-				if !exists {
-					clientInfo = &client{
-						limiter: rate.NewLimiter(rate.Limit(trustedClient.RateLimitRPS), trustedClient.RateLimitBurst),
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			client, err := app.lookupAPIKey(r, apiKey)
+			if err == nil || errors.Is(err, data.ErrRecordNotFound) {
+				// Hand the outcome on so that authenticate needn't repeat it.
+				r = app.contextSetAPIKeyClient(r, client, err)
+			}
+			if err == nil {
+				// Log the request as the client's even if it is rejected.
+				app.contextNoteClient(r, client.ID)
+
+				if client.Enabled && !client.Expired() && client.AllowsIP(app.contextGetClientIP(r)) {
+					// Use client-specific rate limits, keyed by ID so that the
+					// API key itself is never stored by the limiter.
+					l := limit{rps: float64(client.RateLimitRPS), burst: client.RateLimitBurst}
+					if !app.allow(w, r, "client", fmt.Sprintf("client:%d", client.ID), l) {
+						return
 					}
-					mu.Lock()
-					clients[apiKey] = clientInfo
-					mu.Unlock()
+
+					next.ServeHTTP(w, r)
+					return
 				}
+			}
+		}
 
-				clientInfo.lastSeen = time.Now()
+		if routePattern(r) != unmatchedRoute {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-				if !clientInfo.limiter.Allow() {
-					app.rateLimitExceededResponse(w, r)
+		if !app.allowIP(w, r, s) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowIP applies the default per-IP limit, for requests that no route group
+// policy covers.
+func (app *application) allowIP(w http.ResponseWriter, r *http.Request, s *settings) bool {
+	l := limit{rps: s.limiter.rps, burst: s.limiter.burst}
+	return app.allow(w, r, "ip", "ip:"+app.contextGetClientIP(r).String(), l)
+}
+
+// rateLimitGroup applies the rate limit policy of a route group, set by
+// -limiter-groups. Requests are counted per user, or per IP for anonymous
+// requests. Outside the auth group, users holding a role in -limiter-roles get
+// the most generous of their role limits instead. A group without a policy
+// gets the default per-IP limit, apart from trusted clients, which rateLimit
+// has already limited.
+func (app *application) rateLimitGroup(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := app.settings.Load()
+			if !s.limiter.enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			l, ok := s.limiter.groups[group]
+			if !ok {
+				if app.contextGetClient(r) == nil && !app.allowIP(w, r, s) {
 					return
 				}
 
-				// Log the request for auditing
-				app.background(func() {
-					err := app.models.TrustedClients.LogRequest(
-						trustedClient.ID,
-						r.URL.Path,
-						r.Method,
-						http.StatusOK,
-					)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := "group:" + group + ":ip:" + app.contextGetClientIP(r).String()
+
+			user := app.contextGetUser(r)
+			if client := app.contextGetClient(r); client != nil {
+				key = fmt.Sprintf("group:%s:client:%d", group, client.id)
+			} else if !user.IsAnonymous() {
+				key = fmt.Sprintf("group:%s:user:%d", group, user.ID)
+
+				if group != "auth" && len(s.limiter.roles) > 0 {
+					roles, err := app.userRoles(r.Context(), user.ID)
 					if err != nil {
-						app.logger.Error(err.Error())
+						app.serverErrorResponse(w, r, err)
+						return
 					}
-				})
 
-				next.ServeHTTP(w, r)
+					var roleLimit limit
+					for _, role := range roles {
+						if rl, ok := s.limiter.roles[role]; ok && rl.rps > roleLimit.rps {
+							roleLimit = rl
+						}
+					}
+					if roleLimit.burst > 0 {
+						l = roleLimit
+					}
+				}
+			}
+
+			if !app.allow(w, r, group, key, l) {
 				return
 			}
-		}
 
-		// Default rate limiting for regular clients
-		ip := r.RemoteAddr
-		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
-			ip = realIP
-		}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-		mu.RLock()
-		clientInfo, exists := clients[ip]
-		mu.RUnlock()
+// allowLogin applies the -limiter-login-email limit to a login attempt for
+// email, regardless of where the attempts come from, to slow down credential
+// stuffing against a single account. It sends a 429 and returns false when
+// the limit is exceeded.
+func (app *application) allowLogin(w http.ResponseWriter, r *http.Request, email string) bool {
+	s := app.settings.Load()
 
-		if !exists {
-			clientInfo = &client{
-				limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-			}
-			mu.Lock()
-			clients[ip] = clientInfo
-			mu.Unlock()
-		}
+	l := s.limiter.loginEmail
+	if !s.limiter.enabled || l.burst == 0 {
+		return true
+	}
 
-		clientInfo.lastSeen = time.Now()
+	return app.allow(w, r, "login", "login:"+strings.ToLower(email), l)
+}
 
-		if !clientInfo.limiter.Allow() {
-			app.rateLimitExceededResponse(w, r)
-			return
-		}
+// allow checks a request against a limit, setting the rate limit headers. It
+// sends a 429 and returns false if the request is rejected. If the limiter
+// store fails the request is allowed.
+func (app *application) allow(w http.ResponseWriter, r *http.Request, kind, key string, l limit) bool {
+	result, err := app.limiter.allow(r.Context(), key, l)
+	if err != nil {
+		// Fail open: an unavailable limiter store shouldn't take the whole
+		// API down with it.
+		app.logger.Error("rate limiter unavailable; allowing request", "limiter", kind, "error", err)
+		return true
+	}
 
-		next.ServeHTTP(w, r)
+	result.writeHeaders(w.Header())
+
+	if !result.allowed {
+		app.instruments.rateLimitRejections.WithLabelValues(kind).Inc()
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// throttle caps the number of concurrent requests in a route group at the
+// -limiter-concurrency setting.
+func (app *application) throttle(group string) func(http.Handler) http.Handler {
+	n := app.config.limiter.concurrency[group]
+	if n == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return middleware.Throttle(n)
+}
+
+// clientIP resolves the client's IP address, taking forwarding headers from
+// trusted proxies into account, and stores it in the request context. Use
+// contextGetClientIP rather than r.RemoteAddr or the headers directly.
+func (app *application) clientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := resolveClientIP(r, app.config.trustedProxies)
+		next.ServeHTTP(w, app.contextSetClientIP(r, ip))
+	})
+}
+
+// enableCORS applies the CORS policy from the current settings.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.settings.Load().cors.Handler(next).ServeHTTP(w, r)
 	})
 }
 
@@ -203,6 +318,13 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Get the Authorization header
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
+			// Trusted clients may authenticate with their API key instead
+			// of an OAuth access token.
+			if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+				app.authenticateAPIKey(w, r, next, apiKey)
+				return
+			}
+
 			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
@@ -225,15 +347,35 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// Get user with a context timeout
-		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
-		defer cancel()
+		ctx := r.Context()
 
 		user, err := app.models.Users.GetForToken(ctx, data.ScopeAuthentication, token)
-		if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			// Not a user's token, but it may be an OAuth access token
+			// issued to a trusted client.
+			clientToken, err := app.models.Tokens.GetForClient(ctx, token)
 			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
+			case err == nil && clientToken.Scope == data.ScopeClientAccess:
+				client, err := app.models.TrustedClients.Get(ctx, clientToken.ClientID)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+
+				if !app.checkTrustedClient(w, r, client) {
+					return
+				}
+
+				app.serveAsClient(w, r, next, client, clientToken.Scopes)
+			case err == nil || errors.Is(err, data.ErrRecordNotFound):
 				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if err != nil {
+			switch {
 			case errors.Is(err, context.DeadlineExceeded):
 				app.serverErrorResponse(w, r, errors.New("authentication timed out"))
 			default:
@@ -242,11 +384,79 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		logging.With(r.Context(), "user_id", user.ID)
+
 		r = app.contextSetUser(r, user)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticateAPIKey authenticates a trusted client by its API key, giving it
+// the permissions assigned to the client.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, apiKey string) {
+	client, err := app.lookupAPIKey(r, apiKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkTrustedClient(w, r, client) {
+		return
+	}
+	app.keyUsage.record(client.KeyID)
+
+	permissions, err := app.models.Permissions.GetAllForClient(r.Context(), client.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.serveAsClient(w, r, next, client, permissions)
+}
+
+// lookupAPIKey returns the trusted client holding apiKey, reusing what
+// rateLimit found if it already looked the key up.
+func (app *application) lookupAPIKey(r *http.Request, apiKey string) (*data.TrustedClient, error) {
+	if lookup := app.contextGetAPIKeyClient(r); lookup != nil {
+		return lookup.client, lookup.err
+	}
+	return app.models.TrustedClients.GetByAPIKey(r.Context(), apiKey)
+}
+
+// checkTrustedClient checks that a trusted client can authenticate at the
+// moment, from the client's IP address. If not, it sends an error response
+// and returns false. Either way the request is logged as the client's.
+func (app *application) checkTrustedClient(w http.ResponseWriter, r *http.Request, client *data.TrustedClient) bool {
+	app.contextNoteClient(r, client.ID)
+
+	switch {
+	case !client.Enabled:
+		app.invalidAPIKeyResponse(w, r)
+	case client.Expired():
+		app.expiredAPIKeyResponse(w, r)
+	case !client.AllowsIP(app.contextGetClientIP(r)):
+		app.clientIPNotAllowedResponse(w, r)
+	default:
+		return true
+	}
+	return false
+}
+
+// serveAsClient passes the request on authenticated as the trusted client,
+// limited to the given permissions.
+func (app *application) serveAsClient(w http.ResponseWriter, r *http.Request, next http.Handler, client *data.TrustedClient, permissions data.Permissions) {
+	logging.With(r.Context(), "client_id", client.ID)
+
+	r = app.contextSetUser(r, data.AnonymousUser)
+	r = app.contextSetClient(r, &clientPrincipal{id: client.ID, permissions: permissions})
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) requirePermission(code string) func(http.Handler) http.Handler {
 	var cache sync.Map
 	const cacheDuration = 5 * time.Minute
@@ -269,7 +479,7 @@ func (app *application) requirePermission(code string) func(http.Handler) http.H
 			}
 
 			// Cache miss or expired, fetch from database
-			permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+			permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
 			if err != nil {
 				app.serverErrorResponse(w, r, err)
 				return
@@ -289,7 +499,22 @@ func (app *application) requirePermission(code string) func(http.Handler) http.H
 			next.ServeHTTP(w, r)
 		})
 
-		return app.requireActivatedUser(fn)
+		userHandler := app.requireActivatedUser(fn)
+
+		// Trusted clients have the permissions granted to their token
+		// instead of a user's.
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if client := app.contextGetClient(r); client != nil {
+				if !client.permissions.Include(code) {
+					app.notPermittedResponse(w, r)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userHandler.ServeHTTP(w, r)
+		})
 	}
 }
 
@@ -334,7 +559,7 @@ func (app *application) validateRequest(next http.Handler) http.Handler {
 		// Validate Accept header for API requests
 		if strings.HasPrefix(r.URL.Path, "/v1") {
 			accept := r.Header.Get("Accept")
-			if accept != "" && accept != "*/*" && !strings.Contains(accept, "application/json") {
+			if accept != "" && accept != "*/*" && !containsAny(accept, supportedMediaTypes) {
 				app.errorResponse(w, r, http.StatusNotAcceptable, ERRCODE_NOT_ACCEPTABLE, "content type not acceptable", nil)
 				return
 			}
@@ -342,8 +567,8 @@ func (app *application) validateRequest(next http.Handler) http.Handler {
 
 		// Validate Content-Type for requests with bodies
 		if r.ContentLength > 0 {
-			contentType := r.Header.Get("Content-Type")
-			if contentType != "application/json" {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || !slices.Contains(supportedMediaTypes, mediaType) {
 				app.errorResponse(w, r, http.StatusUnsupportedMediaType, ERRCODE_UNSUPPORTED_MEDIA, "content type not supported", nil)
 				return
 			}
@@ -440,31 +665,27 @@ func (app *application) securityHeaders(next http.Handler) http.Handler {
 }
 
 func (app *application) tracing(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get trace ID from header or generate new one
-		traceID := r.Header.Get("X-Request-ID")
-		if traceID == "" {
-			traceID = middleware.GetReqID(r.Context())
-		}
-
-		// Add trace ID to response headers
-		w.Header().Set("X-Request-ID", traceID)
+	tracer := otel.Tracer(tracerName)
+	propagator := otel.GetTextMapPropagator()
 
-		// Create a new context with trace information
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, traceIDContextKey, traceID)
-		ctx = context.WithValue(ctx, startTimeContextKey, time.Now())
-		ctx = context.WithValue(ctx, userAgentContextKey, r.UserAgent())
-		ctx = context.WithValue(ctx, remoteAddrContextKey, r.RemoteAddr)
-
-		// Log request start
-		app.logger.Info("request started",
-			"trace_id", traceID,
-			"method", r.Method,
-			"path", r.URL.Path,
-			"remote_addr", r.RemoteAddr,
-			"user_agent", r.UserAgent(),
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Echo the request ID so clients can correlate their logs with ours
+		w.Header().Set("X-Request-ID", middleware.GetReqID(r.Context()))
+
+		// Continue the caller's trace if a traceparent header was sent. The
+		// span is renamed once routing has resolved the route pattern.
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("user_agent.original", r.UserAgent()),
+				attribute.String("client.address", app.contextGetClientIP(r).String()),
+				attribute.String("network.peer.address", r.RemoteAddr),
+			),
 		)
+		defer span.End()
 
 		// Create response wrapper to capture status code
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
@@ -472,18 +693,15 @@ func (app *application) tracing(next http.Handler) http.Handler {
 		// Process request
 		next.ServeHTTP(ww, r.WithContext(ctx))
 
-		// Calculate request duration
-		duration := time.Since(ctx.Value(startTimeContextKey).(time.Time))
-
-		// Log request completion
-		app.logger.Info("request completed",
-			"trace_id", traceID,
-			"method", r.Method,
-			"path", r.URL.Path,
-			"status", ww.Status(),
-			"duration", duration,
-			"bytes_written", ww.BytesWritten(),
-		)
+		if route := routePattern(r); route != unmatchedRoute {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+
+		span.SetAttributes(attribute.Int("http.response.status_code", ww.Status()))
+		if ww.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(ww.Status()))
+		}
 	})
 }
 
@@ -491,6 +709,17 @@ func (app *application) tracing(next http.Handler) http.Handler {
 func (app *application) requireResourcePermission(resourceType string, permission string, getResourceID func(*http.Request) (int64, error)) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Resource permissions are only granted to users, so trusted
+			// clients need the global permission.
+			if client := app.contextGetClient(r); client != nil {
+				if !client.permissions.Include(permission) {
+					app.notPermittedResponse(w, r)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			user := app.contextGetUser(r)
 
 			// Get the resource ID using the provided function
@@ -501,7 +730,7 @@ func (app *application) requireResourcePermission(resourceType string, permissio
 			}
 
 			// Check if user has the required permission for this resource
-			hasPermission, err := app.models.ResourcePermissions.HasPermission(user.ID, resourceType, resourceID, permission)
+			hasPermission, err := app.models.ResourcePermissions.HasPermission(r.Context(), user.ID, resourceType, resourceID, permission)
 			if err != nil {
 				app.serverErrorResponse(w, r, err)
 				return
@@ -509,7 +738,7 @@ func (app *application) requireResourcePermission(resourceType string, permissio
 
 			if !hasPermission {
 				// Check if user has global permission for this action
-				permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+				permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
 				if err != nil {
 					app.serverErrorResponse(w, r, err)
 					return
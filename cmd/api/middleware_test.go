@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/shadyar-bakr/greenlight/internal/data"
+)
+
+// countingDB is a data.DBTX that finds no rows and counts the queries made.
+type countingDB struct {
+	data.DBTX
+	queries atomic.Int64
+}
+
+func (db *countingDB) QueryRow(context.Context, string, ...any) pgx.Row {
+	db.queries.Add(1)
+	return noRow{}
+}
+
+type noRow struct{}
+
+func (noRow) Scan(...any) error { return pgx.ErrNoRows }
+
+func TestAPIKeyLookedUpOnce(t *testing.T) {
+	tests := []struct {
+		name           string
+		limiterEnabled bool
+	}{
+		{name: "rate limiter enabled", limiterEnabled: true},
+		{name: "rate limiter disabled", limiterEnabled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _, err := loadConfig([]string{"-limiter-enabled=" + strconv.FormatBool(tt.limiterEnabled)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db := &countingDB{}
+			app := &application{
+				config:      cfg,
+				logger:      discardLogger(),
+				instruments: newInstruments(nil),
+				limiter:     newMemoryLimiterStore(time.Minute),
+				models:      data.Models{TrustedClients: data.TrustedClientModel{DB: db}},
+			}
+			app.settings.Store(newSettings(cfg))
+
+			r := chi.NewRouter()
+			r.Use(app.clientIP, app.rateLimit, app.authenticate)
+			r.Get("/v1/movies", func(w http.ResponseWriter, r *http.Request) {})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			req.Header.Set("X-API-Key", "NOT-A-REAL-KEY")
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("got status %d; want %d", rr.Code, http.StatusUnauthorized)
+			}
+			if n := db.queries.Load(); n != 1 {
+				t.Errorf("API key looked up %d times; want 1", n)
+			}
+		})
+	}
+}
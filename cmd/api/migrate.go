@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/tern/v2/migrate"
+	"github.com/joho/godotenv"
+	"github.com/shadyar-bakr/greenlight/internal/data"
+	"github.com/shadyar-bakr/greenlight/migrations"
+)
+
+// versionTable is where tern records the current schema version.
+const versionTable = "public.schema_version"
+
+const migrationTemplate = `BEGIN;
+
+-- Write your migrate up statements here
+
+COMMIT;
+
+---- create above / drop below ----
+
+BEGIN;
+
+-- Write your migrate down statements here
+
+COMMIT;
+`
+
+var migrationNameRX = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func newMigrator(ctx context.Context, conn *pgx.Conn, logger *slog.Logger) (*migrate.Migrator, error) {
+	m, err := migrate.NewMigrator(ctx, conn, versionTable)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.LoadMigrations(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+
+	m.OnStart = func(sequence int32, name, direction, _ string) {
+		logger.Info("applying migration", "version", sequence, "name", name, "direction", direction)
+	}
+
+	return m, nil
+}
+
+// migrateDB applies all pending embedded migrations. Tern holds a Postgres
+// advisory lock while migrating, so instances starting at the same time apply
+// each migration only once.
+func migrateDB(ctx context.Context, pool *pgxpool.Pool, logger *slog.Logger) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	m, err := newMigrator(ctx, conn.Conn(), logger)
+	if err != nil {
+		return err
+	}
+
+	return m.Migrate(ctx)
+}
+
+// checkSchemaVersion returns an error unless the database schema is exactly at
+// the version of the newest embedded migration.
+func checkSchemaVersion(ctx context.Context, schema data.SchemaModel, expected int) error {
+	current, err := schema.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to read schema version: %w", err)
+	}
+
+	switch {
+	case current < expected:
+		return fmt.Errorf("database schema is at version %d but version %d is required; run migrations first", current, expected)
+	case current > expected:
+		return fmt.Errorf("database schema is at version %d, newer than version %d known to this build", current, expected)
+	}
+
+	return nil
+}
+
+// runMigrateCommand implements `greenlight migrate up|down|status|new`.
+func runMigrateCommand(args []string) error {
+	// Unlike the server, migrations can run without a .env file, for example
+	// from a deploy script with the DSN passed as a flag.
+	_ = godotenv.Load()
+
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dsn := fs.String("db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PostgreSQL DSN")
+	dir := fs.String("dir", "migrations", "Directory new migrations are created in")
+	to := fs.Int("to", -1, "Version to migrate up to (default latest)")
+	steps := fs.Int("steps", 1, "Number of migrations to roll back")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s migrate [flags] up|down|status|new <name>\n\nFlags:\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing migrate command")
+	}
+
+	if fs.Arg(0) == "new" {
+		if fs.NArg() != 2 {
+			return errors.New("usage: migrate new <name>")
+		}
+		return newMigrationFile(*dir, fs.Arg(1))
+	}
+
+	if *dsn == "" {
+		return errors.New("database DSN is required")
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, *dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	m, err := newMigrator(ctx, conn, logger)
+	if err != nil {
+		return err
+	}
+
+	current, err := m.GetCurrentVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "up":
+		target := int32(len(m.Migrations))
+		if *to >= 0 {
+			target = int32(*to)
+		}
+		if target < current {
+			return fmt.Errorf("target version %d is below current version %d; use down", target, current)
+		}
+		return m.MigrateTo(ctx, target)
+	case "down":
+		target := current - int32(*steps)
+		if *steps < 1 || target < 0 {
+			return fmt.Errorf("cannot roll back %d migration(s) from version %d", *steps, current)
+		}
+		return m.MigrateTo(ctx, target)
+	case "status":
+		for _, migration := range m.Migrations {
+			state := "pending"
+			if migration.Sequence <= current {
+				state = "applied"
+			}
+			fmt.Printf("%-8s %s\n", state, migration.Name)
+		}
+		fmt.Printf("\nversion: %d of %d\n", current, len(m.Migrations))
+		return nil
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown migrate command %q", fs.Arg(0))
+	}
+}
+
+// newMigrationFile creates the next numbered migration in dir.
+func newMigrationFile(dir, name string) error {
+	if !migrationNameRX.MatchString(name) {
+		return fmt.Errorf("invalid migration name %q: use lowercase letters, digits and underscores", name)
+	}
+
+	paths, err := migrate.FindMigrations(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%03d_%s.sql", len(paths)+1, name))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(migrationTemplate)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("created", path)
+	return nil
+}
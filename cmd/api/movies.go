@@ -1,12 +1,10 @@
 package main
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/shadyar-bakr/greenlight/internal/data"
 	"github.com/shadyar-bakr/greenlight/internal/validator"
@@ -44,8 +42,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
-	defer cancel()
+	ctx := r.Context()
 
 	err = app.models.Movies.Insert(ctx, movie)
 	if err != nil {
@@ -53,20 +50,22 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Grant resource-level permissions to the creator
-	user := app.contextGetUser(r)
-	resourcePermission := &data.ResourcePermission{
-		UserID:       user.ID,
-		ResourceType: "movie",
-		ResourceID:   movie.ID,
-		Permission:   "movies:write",
-		GrantedBy:    &user.ID,
-	}
+	// Grant resource-level permissions to the creator. Movies created by
+	// trusted clients have no creator to grant them to.
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		resourcePermission := &data.ResourcePermission{
+			UserID:       user.ID,
+			ResourceType: "movie",
+			ResourceID:   movie.ID,
+			Permission:   "movies:write",
+			GrantedBy:    &user.ID,
+		}
 
-	err = app.models.ResourcePermissions.Grant(resourcePermission)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+		err = app.models.ResourcePermissions.Grant(r.Context(), resourcePermission)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 
 	headers := make(http.Header)
@@ -90,8 +89,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
-	defer cancel()
+	ctx := r.Context()
 
 	movie, err := app.models.Movies.Get(ctx, id)
 	if err != nil {
@@ -122,8 +120,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
-	defer cancel()
+	ctx := r.Context()
 
 	movie, err := app.models.Movies.Get(ctx, id)
 	if err != nil {
@@ -204,8 +201,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
-	defer cancel()
+	ctx := r.Context()
 
 	err = app.models.Movies.Delete(ctx, id)
 	if err != nil {
@@ -264,8 +260,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
-	defer cancel()
+	ctx := r.Context()
 
 	// Get the movies from the database
 	movies, metadata, err := app.models.Movies.GetAll(ctx, input.Title, input.Genres, input.Filters)
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shadyar-bakr/greenlight/internal/data"
+	"github.com/shadyar-bakr/greenlight/internal/validator"
+)
+
+const (
+	bulkModeAtomic     = "atomic"
+	bulkModeBestEffort = "best-effort"
+
+	maxBulkBytes = 1_048_576 // 1MB, matching validateRequest
+	maxBulkRows  = 10_000
+
+	// csvGenreSeparator separates genres inside the single CSV genres column.
+	csvGenreSeparator = "|"
+
+	// exportTimeout is how long an export may take, in place of the server's
+	// write timeout and the request timeout.
+	exportTimeout = 10 * time.Minute
+)
+
+var csvColumns = []string{"title", "year", "runtime", "genres"}
+
+// bulkRowError reports why a single row of a bulk import was rejected. Rows are
+// numbered from 1, not counting a CSV header.
+type bulkRowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+// movieRow holds either a decoded movie or the reason it could not be decoded.
+type movieRow struct {
+	movie *data.Movie
+	err   error
+}
+
+// movieRowInput mirrors the createMovieHandler input. Unknown fields are
+// permitted so that an NDJSON export (which includes id and version) can be
+// imported again as-is.
+type movieRowInput struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+}
+
+func (in movieRowInput) movie() *data.Movie {
+	return &data.Movie{
+		Title:   in.Title,
+		Year:    in.Year,
+		Runtime: in.Runtime,
+		Genres:  in.Genres,
+	}
+}
+
+func (app *application) bulkCreateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	mode := app.readString(qs, "mode", bulkModeAtomic)
+	v.Check(validator.PermittedValue(mode, bulkModeAtomic, bulkModeBestEffort), "mode", "must be either atomic or best-effort")
+
+	async := app.readString(qs, "async", "false")
+	v.Check(validator.PermittedValue(async, "true", "false"), "async", "must be either true or false")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("body must have a valid Content-Type"))
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxBulkBytes)
+
+	var rows []movieRow
+
+	switch mediaType {
+	case "application/json":
+		rows, err = readMovieRowsJSON(body)
+	case "application/x-ndjson":
+		rows, err = readMovieRowsNDJSON(body)
+	case "text/csv":
+		rows, err = readMovieRowsCSV(body)
+	default:
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType, ERRCODE_UNSUPPORTED_MEDIA, "content type not supported", nil)
+		return
+	}
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			err = fmt.Errorf("body must not be larger than %d bytes", maxBulkBytes)
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	switch {
+	case len(rows) == 0:
+		app.badRequestResponse(w, r, errors.New("body must contain at least one movie"))
+		return
+	case len(rows) > maxBulkRows:
+		app.badRequestResponse(w, r, fmt.Errorf("body must not contain more than %d movies", maxBulkRows))
+		return
+	}
+
+	movies := make([]*data.Movie, 0, len(rows))
+	rowErrors := []bulkRowError{}
+
+	for i, row := range rows {
+		if row.err != nil {
+			rowErrors = append(rowErrors, bulkRowError{Row: i + 1, Errors: map[string]string{"row": row.err.Error()}})
+			continue
+		}
+
+		v := validator.New()
+		if data.ValidateMovie(v, row.movie); !v.Valid() {
+			rowErrors = append(rowErrors, bulkRowError{Row: i + 1, Errors: v.Errors})
+			continue
+		}
+
+		movies = append(movies, row.movie)
+	}
+
+	if len(rowErrors) > 0 && mode == bulkModeAtomic {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, ERRCODE_VALIDATION, "validation failed", rowErrors)
+		return
+	}
+
+	// Asynchronous imports are validated up front, so row errors are still
+	// reported immediately, and the insert itself runs on the job queue.
+	if async == "true" && len(movies) > 0 {
+		job, err := app.queue.Enqueue(r.Context(), jobImportMovies, importMoviesPayload{Movies: movies})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"job": job, "queued": len(movies), "failed": rowErrors}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var imported int64
+
+	if len(movies) > 0 {
+		imported, err = app.models.Movies.InsertMany(r.Context(), movies)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"imported": imported, "failed": rowErrors}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func readMovieRowsJSON(body io.Reader) ([]movieRow, error) {
+	var items []json.RawMessage
+
+	err := json.NewDecoder(body).Decode(&items)
+	if err != nil {
+		return nil, fmt.Errorf("body must contain a JSON array of movies: %w", err)
+	}
+
+	rows := make([]movieRow, len(items))
+	for i, item := range items {
+		rows[i] = decodeMovieRow(item)
+	}
+
+	return rows, nil
+}
+
+func readMovieRowsNDJSON(body io.Reader) ([]movieRow, error) {
+	var rows []movieRow
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBulkBytes)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		rows = append(rows, decodeMovieRow([]byte(line)))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func decodeMovieRow(raw []byte) movieRow {
+	var input movieRowInput
+
+	err := json.Unmarshal(raw, &input)
+	if err != nil {
+		return movieRow{err: errors.New("contains malformed JSON")}
+	}
+
+	return movieRow{movie: input.movie()}
+}
+
+// readMovieRowsCSV reads a CSV document whose first record is a header naming
+// the columns. The title, year, runtime and genres columns are required; any
+// other columns (such as id in an export) are ignored.
+func readMovieRowsCSV(body io.Reader) ([]movieRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range csvColumns {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("CSV header must include a %q column", name)
+		}
+	}
+
+	var rows []movieRow
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) {
+				rows = append(rows, movieRow{err: parseError.Err})
+				continue
+			}
+			return nil, err
+		}
+
+		rows = append(rows, parseMovieRecord(record, index))
+	}
+
+	return rows, nil
+}
+
+func parseMovieRecord(record []string, index map[string]int) movieRow {
+	field := func(name string) string {
+		i := index[name]
+		if i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	movie := &data.Movie{Title: field("title")}
+
+	if s := field("year"); s != "" {
+		year, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return movieRow{err: errors.New("year must be an integer value")}
+		}
+		movie.Year = int32(year)
+	}
+
+	if s := field("runtime"); s != "" {
+		runtime, err := data.ParseRuntime(s)
+		if err != nil {
+			return movieRow{err: errors.New("runtime must be a number of minutes")}
+		}
+		movie.Runtime = runtime
+	}
+
+	if s := field("genres"); s != "" {
+		movie.Genres = []string{}
+		for _, genre := range strings.Split(s, csvGenreSeparator) {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				movie.Genres = append(movie.Genres, genre)
+			}
+		}
+	}
+
+	return movieRow{movie: movie}
+}
+
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title  string
+		Genres []string
+		Format string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	defaultFormat := "ndjson"
+	if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		defaultFormat = "csv"
+	}
+
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Format = app.readString(qs, "format", defaultFormat)
+
+	input.Filters.SortBy = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+	v.Check(validator.PermittedValue(input.Format, "ndjson", "csv"), "format", "must be either ndjson or csv")
+	v.Check(validator.PermittedValue(input.Filters.SortBy, input.Filters.SortSafeList...), "sort", "invalid sort value")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var (
+		contentType, filename string
+		begin                 func() error
+		write                 func(*data.Movie) error
+		finish                func() error
+	)
+
+	switch input.Format {
+	case "csv":
+		contentType, filename = "text/csv; charset=utf-8", "movies.csv"
+
+		cw := csv.NewWriter(w)
+		begin = func() error {
+			return cw.Write(append([]string{"id"}, csvColumns...))
+		}
+		write = func(movie *data.Movie) error {
+			return cw.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.Itoa(int(movie.Year)),
+				strconv.Itoa(int(movie.Runtime)),
+				strings.Join(movie.Genres, csvGenreSeparator),
+			})
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		contentType, filename = "application/x-ndjson", "movies.ndjson"
+
+		enc := json.NewEncoder(w)
+		begin = func() error { return nil }
+		write = func(movie *data.Movie) error {
+			return enc.Encode(movie)
+		}
+		finish = func() error { return nil }
+	}
+
+	// An export can take far longer than an ordinary request, so it gets its
+	// own deadlines. The query still stops if the client goes away, but not
+	// when the request timeout passes.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(exportTimeout))
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), exportTimeout)
+	defer cancel()
+
+	stop := context.AfterFunc(r.Context(), func() {
+		if errors.Is(r.Context().Err(), context.Canceled) {
+			cancel()
+		}
+	})
+	defer stop()
+
+	// Nothing is sent until the first movie has been read, so that a query
+	// that fails up front gets an error response rather than a 200 with an
+	// empty export.
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		w.WriteHeader(http.StatusOK)
+		return begin()
+	}
+
+	count := 0
+
+	err := app.models.Movies.Stream(ctx, input.Title, input.Genres, input.Filters, func(movie *data.Movie) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		err := write(movie)
+		if err != nil {
+			return err
+		}
+
+		count++
+		if count%100 == 0 {
+			if err := finish(); err != nil {
+				return err
+			}
+			_ = rc.Flush()
+		}
+
+		return nil
+	})
+
+	switch {
+	case err != nil && !started:
+		app.serverErrorResponse(w, r, err)
+		return
+	case err == nil && !started:
+		// No movies matched; the export is just the header, if any.
+		err = start()
+	}
+	if err == nil {
+		err = finish()
+	}
+
+	// The status line has already been sent, so abort the connection rather
+	// than end the body normally; otherwise the client couldn't tell a
+	// truncated export from a complete one.
+	if err != nil {
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
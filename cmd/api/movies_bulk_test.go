@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExportMovies(t *testing.T) {
+	db := newTestDB(t)
+	app, _ := newTestApplication(t, db)
+
+	tests := []struct {
+		name            string
+		url             string
+		cancelled       bool
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "no matches",
+			url:             "/v1/movies/export?format=csv&title=no-such-movie-xyzzy",
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/csv; charset=utf-8",
+			wantBody:        "id,title,year,runtime,genres\n",
+		},
+		{
+			name:            "query fails before the first row",
+			url:             "/v1/movies/export?format=csv",
+			cancelled:       true,
+			wantStatus:      http.StatusInternalServerError,
+			wantContentType: "application/json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.cancelled {
+				ctx, cancel := context.WithCancel(r.Context())
+				cancel()
+				r = r.WithContext(ctx)
+			}
+			rr := httptest.NewRecorder()
+
+			app.exportMoviesHandler(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("got Content-Type %q; want %q", got, tt.wantContentType)
+			}
+			if tt.wantBody != "" && rr.Body.String() != tt.wantBody {
+				t.Errorf("got body %q; want %q", rr.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
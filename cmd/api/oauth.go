@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shadyar-bakr/greenlight/internal/data"
+)
+
+const (
+	oauthAccessTokenTTL  = 15 * time.Minute
+	oauthRefreshTokenTTL = 24 * time.Hour
+)
+
+// clientPrincipal is a trusted client making a request on its own behalf
+// rather than for a user, limited to the permissions it was granted.
+type clientPrincipal struct {
+	id          int64
+	permissions data.Permissions
+}
+
+// oauthErrorResponse sends an error in the format RFC 6749 section 5.2
+// requires, which OAuth client libraries expect instead of our own envelope.
+func (app *application) oauthErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	err := app.writeJSON(w, status, envelope{"error": code, "error_description": description}, headers)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+// parseOAuthForm parses the form-encoded body of an OAuth request, sending an
+// invalid_request error and returning false if it can't.
+func (app *application) parseOAuthForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "the body must be a valid form-encoded request")
+		return false
+	}
+
+	return true
+}
+
+// authenticateClient authenticates the trusted client calling an OAuth
+// endpoint from HTTP Basic credentials or, failing that, the client_id and
+// client_secret form parameters. The client ID is the trusted client's ID and
+// the secret its API key. It sends an invalid_client error and returns nil if
+// the client can't be authenticated.
+func (app *application) authenticateClient(w http.ResponseWriter, r *http.Request) *data.TrustedClient {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form-encodes the credentials before they
+		// are base64 encoded, so they must be decoded again.
+		var idErr, secretErr error
+		clientID, idErr = url.QueryUnescape(clientID)
+		secret, secretErr = url.QueryUnescape(secret)
+		if idErr != nil || secretErr != nil {
+			clientID, secret = "", ""
+		}
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	id, err := strconv.ParseInt(clientID, 10, 64)
+	if err == nil && secret != "" {
+		client, err := app.models.TrustedClients.GetByAPIKey(r.Context(), secret)
+		switch {
+		case err == nil && client.ID == id && client.Enabled && !client.Expired() && client.AllowsIP(app.contextGetClientIP(r)):
+			app.keyUsage.record(client.KeyID)
+			return client
+		case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return nil
+		}
+	}
+
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="greenlight"`)
+	}
+	app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+	return nil
+}
+
+// oauthTokenHandler is the OAuth token endpoint. It supports the client
+// credentials grant, for trusted clients acting on their own behalf, and the
+// refresh token grant for the tokens issued by it.
+func (app *application) oauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.parseOAuthForm(w, r) {
+		return
+	}
+
+	client := app.authenticateClient(w, r)
+	if client == nil {
+		return
+	}
+
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case "client_credentials":
+		app.clientCredentialsGrant(w, r, client)
+	case "refresh_token":
+		app.refreshTokenGrant(w, r, client)
+	case "":
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "grant_type must be provided")
+	default:
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_grant_type", "unsupported grant type "+strconv.Quote(grantType))
+	}
+}
+
+func (app *application) clientCredentialsGrant(w http.ResponseWriter, r *http.Request, client *data.TrustedClient) {
+	allowed, err := app.models.Permissions.GetAllForClient(r.Context(), client.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	scopes, ok := requestedScopes(r.PostForm.Get("scope"), allowed)
+	if !ok {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_scope", "the requested scope exceeds the scope granted to the client")
+		return
+	}
+
+	accessToken, refreshToken, err := app.models.Tokens.NewClientPair(r.Context(), client.ID, scopes, oauthAccessTokenTTL, oauthRefreshTokenTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.oauthTokenResponse(w, r, accessToken, refreshToken)
+}
+
+// refreshTokenGrant swaps a refresh token for a new token pair. The old
+// refresh token stops working, and the new pair loses any scopes the client
+// has since had taken away.
+func (app *application) refreshTokenGrant(w http.ResponseWriter, r *http.Request, client *data.TrustedClient) {
+	plaintext := r.PostForm.Get("refresh_token")
+	if plaintext == "" {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "refresh_token must be provided")
+		return
+	}
+
+	token, err := app.models.Tokens.GetForClient(r.Context(), plaintext)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if token == nil || token.Scope != data.ScopeClientRefresh || token.ClientID != client.ID {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid or expired")
+		return
+	}
+
+	allowed, err := app.models.Permissions.GetAllForClient(r.Context(), client.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	granted := slices.DeleteFunc(slices.Clone(token.Scopes), func(code string) bool {
+		return !allowed.Include(code)
+	})
+
+	scopes, ok := requestedScopes(r.PostForm.Get("scope"), granted)
+	if !ok {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_scope", "the requested scope exceeds the scope originally granted")
+		return
+	}
+
+	var accessToken, refreshToken *data.Token
+
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		err := tx.Tokens.DeleteForClient(r.Context(), client.ID, plaintext)
+		if err != nil {
+			return err
+		}
+
+		accessToken, refreshToken, err = tx.Tokens.NewClientPair(r.Context(), client.ID, scopes, oauthAccessTokenTTL, oauthRefreshTokenTTL)
+		return err
+	})
+	if err != nil {
+		switch {
+		// Another request used the refresh token first.
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid or expired")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.oauthTokenResponse(w, r, accessToken, refreshToken)
+}
+
+// requestedScopes parses the space separated scope parameter of a token
+// request. An empty parameter asks for everything allowed. It returns false
+// if a scope that isn't allowed is requested.
+func requestedScopes(param string, allowed data.Permissions) (data.Permissions, bool) {
+	requested := strings.Fields(param)
+	if len(requested) == 0 {
+		return allowed, true
+	}
+
+	scopes := data.Permissions{}
+	for _, code := range requested {
+		if !allowed.Include(code) {
+			return nil, false
+		}
+		if !scopes.Include(code) {
+			scopes = append(scopes, code)
+		}
+	}
+
+	return scopes, true
+}
+
+// oauthTokenResponse sends a successful token response as described in RFC
+// 6749 section 5.1.
+func (app *application) oauthTokenResponse(w http.ResponseWriter, r *http.Request, accessToken, refreshToken *data.Token) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+	headers.Set("Pragma", "no-cache")
+
+	err := app.writeJSON(w, http.StatusOK, envelope{
+		"access_token":  accessToken.Plaintext,
+		"token_type":    "Bearer",
+		"expires_in":    int(oauthAccessTokenTTL / time.Second),
+		"refresh_token": refreshToken.Plaintext,
+		"scope":         strings.Join(accessToken.Scopes, " "),
+	}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// oauthIntrospectHandler implements token introspection (RFC 7662). Clients
+// can only introspect their own tokens; any other token is reported inactive,
+// as are invalid and expired ones.
+func (app *application) oauthIntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.parseOAuthForm(w, r) {
+		return
+	}
+
+	client := app.authenticateClient(w, r)
+	if client == nil {
+		return
+	}
+
+	plaintext := r.PostForm.Get("token")
+	if plaintext == "" {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "token must be provided")
+		return
+	}
+
+	token, err := app.models.Tokens.GetForClient(r.Context(), plaintext)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"active": false}
+
+	if token != nil && token.ClientID == client.ID {
+		env = envelope{
+			"active":    true,
+			"client_id": strconv.FormatInt(token.ClientID, 10),
+			"scope":     strings.Join(token.Scopes, " "),
+			"exp":       token.Expiry.Unix(),
+		}
+		if token.Scope == data.ScopeClientAccess {
+			env["token_type"] = "Bearer"
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// oauthRevokeHandler implements token revocation (RFC 7009). As the RFC
+// requires, it succeeds even if the token was already invalid, so a client
+// can't use it to probe for tokens.
+func (app *application) oauthRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.parseOAuthForm(w, r) {
+		return
+	}
+
+	client := app.authenticateClient(w, r)
+	if client == nil {
+		return
+	}
+
+	plaintext := r.PostForm.Get("token")
+	if plaintext == "" {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "token must be provided")
+		return
+	}
+
+	err := app.models.Tokens.DeleteForClient(r.Context(), client.ID, plaintext)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/shadyar-bakr/greenlight/internal/data"
+)
+
+func TestRequestedScopes(t *testing.T) {
+	allowed := data.Permissions{"movies:read", "movies:write"}
+
+	tests := []struct {
+		name   string
+		param  string
+		want   data.Permissions
+		wantOK bool
+	}{
+		{name: "empty asks for everything allowed", param: "", want: allowed, wantOK: true},
+		{name: "only spaces", param: "   ", want: allowed, wantOK: true},
+		{name: "single scope", param: "movies:read", want: data.Permissions{"movies:read"}, wantOK: true},
+		{name: "several scopes", param: "movies:write movies:read", want: data.Permissions{"movies:write", "movies:read"}, wantOK: true},
+		{name: "extra whitespace", param: " movies:read \t movies:write ", want: data.Permissions{"movies:read", "movies:write"}, wantOK: true},
+		{name: "duplicates are dropped", param: "movies:read movies:read", want: data.Permissions{"movies:read"}, wantOK: true},
+		{name: "scope not allowed", param: "movies:read roles:write", wantOK: false},
+		{name: "unknown scope", param: "everything", wantOK: false},
+		{name: "scopes are case sensitive", param: "MOVIES:READ", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := requestedScopes(tt.param, allowed)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %t; want %t", ok, tt.wantOK)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got, ok := requestedScopes("", nil); !ok || len(got) != 0 {
+		t.Errorf("with nothing allowed, empty scope got %q, %t; want none, true", got, ok)
+	}
+	if _, ok := requestedScopes("movies:read", nil); ok {
+		t.Error("with nothing allowed, a requested scope was granted")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/go-chi/cors"
+)
+
+// reloadableFlags are the settings a SIGHUP reload applies to the running
+// server. Changes to any other setting are reported but need a restart.
+var reloadableFlags = []string{
+	"log-level",
+	"limiter-enabled", "limiter-rps", "limiter-burst", "limiter-groups", "limiter-roles", "limiter-login-email",
+	"cors-trusted-origins",
+}
+
+// settings holds the parts of the configuration that can change while the
+// server is running. A reload replaces the whole value, so middleware always
+// sees a consistent set.
+type settings struct {
+	limiter struct {
+		enabled    bool
+		rps        float64
+		burst      int
+		groups     limitSet
+		roles      limitSet
+		loginEmail limit
+	}
+	cors *cors.Cors
+}
+
+func newSettings(cfg config) *settings {
+	s := &settings{}
+	s.limiter.enabled = cfg.limiter.enabled
+	s.limiter.rps = cfg.limiter.rps
+	s.limiter.burst = cfg.limiter.burst
+	s.limiter.groups = cfg.limiter.groups
+	s.limiter.roles = cfg.limiter.roles
+	s.limiter.loginEmail = limit(cfg.limiter.loginEmail)
+	s.cors = cors.New(cors.Options{
+		AllowedOrigins:   cfg.cors.trustedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Request-ID"},
+		ExposedHeaders:   []string{"Link", "X-Request-ID", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+		AllowCredentials: true,
+		MaxAge:           300,
+	})
+	return s
+}
+
+// reloadConfig loads the configuration again from the same file, environment
+// and flags as at startup and applies the reloadable settings. Nothing changes
+// if the new configuration is invalid. Variables in a .env file that were
+// already loaded at startup are not read again.
+func (app *application) reloadConfig() error {
+	cfg, flags, err := loadConfig(os.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		return err
+	}
+
+	values := flagValues(flags)
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	changed := 0
+	for _, name := range names {
+		old, val := app.configValues[name], values[name]
+		if old == val {
+			continue
+		}
+		if slices.Contains(secretFlags, name) {
+			old, val = redact(old), redact(val)
+		}
+
+		if !slices.Contains(reloadableFlags, name) {
+			app.logger.Warn("setting changed but requires a restart", "setting", name, "old", old, "new", val)
+			continue
+		}
+
+		app.logger.Info("setting changed", "setting", name, "old", old, "new", val)
+		app.configValues[name] = values[name]
+		changed++
+	}
+
+	app.logLevel.Set(cfg.log.level)
+	app.settings.Store(newSettings(cfg))
+
+	app.logger.Info("configuration reloaded", "changed", changed)
+	return nil
+}
+
+// flagValues returns the current value of every configuration flag.
+func flagValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		if !slices.Contains(commandFlags, f.Name) {
+			values[f.Name] = f.Value.String()
+		}
+	})
+	return values
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// roleCacheDuration is how long a user's role names are cached for, which is
+// how long a role change takes to affect their rate limits.
+const roleCacheDuration = time.Minute
+
+// roleCache holds the names of users' roles, shared by every route group, as
+// the role limits need them on every request.
+type roleCache struct {
+	mu        sync.Mutex
+	entries   map[int64]roleCacheEntry
+	nextSweep time.Time
+}
+
+type roleCacheEntry struct {
+	roles  []string
+	expiry time.Time
+}
+
+func newRoleCache() *roleCache {
+	return &roleCache{entries: make(map[int64]roleCacheEntry)}
+}
+
+// get returns the cached role names of the user, if they haven't expired.
+func (c *roleCache) get(userID int64) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[userID]
+	if !ok || time.Now().After(entry.expiry) {
+		return nil, false
+	}
+	return entry.roles, true
+}
+
+// set caches the role names of the user. Expired entries are swept out at
+// most once per cache duration, so users who have gone away don't stay in
+// memory.
+func (c *roleCache) set(userID int64, roles []string) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.After(c.nextSweep) {
+		for id, entry := range c.entries {
+			if now.After(entry.expiry) {
+				delete(c.entries, id)
+			}
+		}
+		c.nextSweep = now.Add(roleCacheDuration)
+	}
+
+	c.entries[userID] = roleCacheEntry{roles: roles, expiry: now.Add(roleCacheDuration)}
+}
+
+// userRoles returns the names of the user's roles, from the cache if possible.
+func (app *application) userRoles(ctx context.Context, userID int64) ([]string, error) {
+	if roles, ok := app.roleCache.get(userID); ok {
+		return roles, nil
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = role.Name
+	}
+
+	app.roleCache.set(userID, names)
+	return names, nil
+}
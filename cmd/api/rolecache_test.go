@@ -0,0 +1,36 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRoleCache(t *testing.T) {
+	c := newRoleCache()
+
+	c.set(1, []string{"admin"})
+	roles, ok := c.get(1)
+	if !ok || !slices.Equal(roles, []string{"admin"}) {
+		t.Fatalf("get(1) = %v, %t; want [admin], true", roles, ok)
+	}
+
+	if _, ok := c.get(2); ok {
+		t.Error("get(2) found roles for an uncached user")
+	}
+
+	// Expire the entry and let the next set sweep it out.
+	c.entries[1] = roleCacheEntry{roles: []string{"admin"}, expiry: time.Now().Add(-time.Second)}
+	if _, ok := c.get(1); ok {
+		t.Error("get(1) returned an expired entry")
+	}
+
+	c.nextSweep = time.Time{}
+	c.set(2, []string{"editor"})
+	if _, ok := c.entries[1]; ok {
+		t.Error("expired entry was not evicted")
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("cache holds %d entries; want 1", len(c.entries))
+	}
+}
@@ -40,7 +40,7 @@ func (app *application) createRoleHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Roles.Insert(role)
+	err = app.models.Roles.Insert(r.Context(), role)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -62,7 +62,7 @@ func (app *application) showRoleHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	role, err := app.models.Roles.Get(id)
+	role, err := app.models.Roles.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -86,7 +86,7 @@ func (app *application) updateRoleHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	role, err := app.models.Roles.Get(id)
+	role, err := app.models.Roles.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -131,7 +131,7 @@ func (app *application) updateRoleHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Roles.Update(role)
+	err = app.models.Roles.Update(r.Context(), role)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -155,7 +155,7 @@ func (app *application) deleteRoleHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Roles.Delete(id)
+	err = app.models.Roles.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -173,7 +173,7 @@ func (app *application) deleteRoleHandler(w http.ResponseWriter, r *http.Request
 }
 
 func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
-	roles, err := app.models.Roles.GetAll()
+	roles, err := app.models.Roles.GetAll(r.Context())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -198,7 +198,7 @@ func (app *application) assignRoleHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	user := app.contextGetUser(r)
-	err = app.models.Roles.AssignToUser(input.UserID, input.RoleID, user.ID)
+	err = app.models.Roles.AssignToUser(r.Context(), input.UserID, input.RoleID, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -222,7 +222,7 @@ func (app *application) unassignRoleHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Roles.UnassignFromUser(input.UserID, input.RoleID)
+	err = app.models.Roles.UnassignFromUser(r.Context(), input.UserID, input.RoleID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -246,7 +246,7 @@ func (app *application) listUserRolesHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	roles, err := app.models.Roles.GetAllForUser(id)
+	roles, err := app.models.Roles.GetAllForUser(r.Context(), id)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -265,7 +265,7 @@ func (app *application) listRolePermissionsHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
-	permissions, err := app.models.Roles.GetAllPermissions(id)
+	permissions, err := app.models.Roles.GetAllPermissions(r.Context(), id)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
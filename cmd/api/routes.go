@@ -7,18 +7,17 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
 )
 
 func (app *application) routes() http.Handler {
 	r := chi.NewRouter()
 
 	// Core middleware - order is important
-	r.Use(app.tracing)         // Should be first to trace everything
-	r.Use(app.securityHeaders) // Add security headers early
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
+	r.Use(app.clientIP)        // Resolve the client IP before anything uses it
+	r.Use(app.tracing)         // Trace everything after the request ID is assigned
+	r.Use(app.logRequest)      // Access log; wraps Recoverer so panics are logged as 500s
+	r.Use(app.securityHeaders) // Add security headers early
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(30 * time.Second))
 	r.Use(middleware.CleanPath)
@@ -28,57 +27,79 @@ func (app *application) routes() http.Handler {
 	// Application middleware
 	r.Use(app.metrics)
 	r.Use(app.validateRequest)
-	r.Use(app.rateLimit)
+	r.Use(app.logClientRequest) // Before rateLimit and authenticate so their rejections are logged
+	r.Use(app.rateLimit)        // Trusted clients and unmatched routes; route groups have their own
 	r.Use(app.authenticate)
 
-	// CORS middleware configuration
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   app.config.cors.trustedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Request-ID"},
-		ExposedHeaders:   []string{"Link", "X-Request-ID"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	}))
+	// CORS middleware; the trusted origins can be changed by a reload
+	r.Use(app.enableCORS)
+
+	// One throttle per route group, so that the concurrency cap covers all of
+	// the group's routes rather than each set of them separately.
+	throttles := make(map[string]func(http.Handler) http.Handler, len(routeGroups))
+	for _, group := range routeGroups {
+		throttles[group] = app.throttle(group)
+	}
 
 	r.NotFound(app.notFoundResponse)
 	r.MethodNotAllowed(app.methodNotAllowedResponse)
 
-	// Health check endpoints with rate limiting exemption
+	// Health check endpoints
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.NoCache)
-		r.Use(middleware.Throttle(1000)) // Higher limit for health checks
+		r.Use(throttles["health"], app.rateLimitGroup("health"))
 		r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("pong"))
 		})
 		r.Get("/health", app.healthcheckHandler)
+		r.Get("/health/live", app.liveHandler)
+		r.Get("/health/ready", app.readyHandler)
 	})
 
 	// API routes
 	r.Route("/v1", func(r chi.Router) {
 
-		// Public routes with specific rate limits
+		// Public authentication routes, with the strictest rate limits
 		r.Group(func(r chi.Router) {
-			r.Use(middleware.Throttle(100)) // Lower limit for public endpoints
+			r.Use(throttles["auth"], app.rateLimitGroup("auth"))
 			r.Post("/users", app.registerUserHandler)
 			r.Put("/users/activated", app.activateUserHandler)
+			r.Put("/users/unlocked", app.unlockAccountHandler)
 			r.Post("/tokens/authentication", app.createAuthenticationTokenHandler)
 			r.Post("/tokens/refresh", app.refreshTokenHandler)
+			r.Post("/tokens/mfa", app.createMFATokenHandler)
+
+			// OAuth authorization server for trusted clients
+			r.Post("/oauth/token", app.oauthTokenHandler)
+			r.Post("/oauth/introspect", app.oauthIntrospectHandler)
+			r.Post("/oauth/revoke", app.oauthRevokeHandler)
+		})
+
+		// Two-factor authentication management for the logged in user
+		r.Group(func(r chi.Router) {
+			r.Use(throttles["auth"], app.rateLimitGroup("auth"))
+			r.Use(app.requireActivatedUser)
+			r.Post("/users/me/mfa/totp", app.enrollTOTPHandler)
+			r.Post("/users/me/mfa/totp/confirm", app.confirmTOTPHandler)
+			r.Delete("/users/me/mfa/totp", app.disableTOTPHandler)
+			r.Post("/users/me/mfa/recovery-codes", app.regenerateRecoveryCodesHandler)
 		})
 
 		// Protected routes - movies read
 		r.Group(func(r chi.Router) {
+			r.Use(throttles["read"], app.rateLimitGroup("read"))
 			r.Use(app.requirePermission("movies:read"))
-			r.Use(middleware.Throttle(200)) // Medium limit for read operations
 			r.Get("/movies", app.listMoviesHandler)
+			r.Get("/movies/export", app.exportMoviesHandler)
 			r.Get("/movies/{id}", app.showMovieHandler)
 		})
 
 		// Protected routes - movies write
 		r.Group(func(r chi.Router) {
+			r.Use(throttles["write"], app.rateLimitGroup("write"))
 			r.Use(app.requirePermission("movies:write"))
-			r.Use(middleware.Throttle(50)) // Lower limit for write operations
 			r.Post("/movies", app.createMovieHandler)
+			r.Post("/movies/bulk", app.bulkCreateMoviesHandler)
 
 			// Update and delete require resource-level permissions
 			r.Route("/movies/{id}", func(r chi.Router) {
@@ -91,8 +112,8 @@ func (app *application) routes() http.Handler {
 
 			// Role management routes - admin only
 			r.Group(func(r chi.Router) {
+				r.Use(throttles["admin"], app.rateLimitGroup("admin"))
 				r.Use(app.requirePermission("roles:write"))
-				r.Use(middleware.Throttle(50)) // Lower limit for write operations
 
 				// Role CRUD operations
 				r.Post("/roles", app.createRoleHandler)
@@ -110,8 +131,8 @@ func (app *application) routes() http.Handler {
 
 			// Trusted client management routes - admin only
 			r.Group(func(r chi.Router) {
+				r.Use(throttles["admin"], app.rateLimitGroup("admin"))
 				r.Use(app.requirePermission("trusted-clients:write"))
-				r.Use(middleware.Throttle(50)) // Lower limit for write operations
 
 				// Trusted client CRUD operations
 				r.Post("/trusted-clients", app.createTrustedClientHandler)
@@ -122,8 +143,35 @@ func (app *application) routes() http.Handler {
 
 				// API key management
 				r.Post("/trusted-clients/{id}/regenerate-key", app.regenerateAPIKeyHandler)
+				r.Get("/trusted-clients/{id}/keys", app.listTrustedClientKeysHandler)
+				r.Post("/trusted-clients/{id}/keys", app.createTrustedClientKeyHandler)
+				r.Delete("/trusted-clients/{id}/keys/{keyID}", app.revokeTrustedClientKeyHandler)
+
+				// Permissions the client may request as OAuth scopes
+				r.Get("/trusted-clients/{id}/permissions", app.showTrustedClientPermissionsHandler)
+				r.Put("/trusted-clients/{id}/permissions", app.updateTrustedClientPermissionsHandler)
+
+				// Request counts, error rates and latency
+				r.Get("/trusted-clients/{id}/usage", app.trustedClientUsageHandler)
 			})
 		})
+
+		// Job queue administration - admin only
+		r.Group(func(r chi.Router) {
+			r.Use(throttles["admin"], app.rateLimitGroup("admin"))
+			r.Use(app.requirePermission("jobs:write"))
+			r.Get("/admin/jobs", app.listJobsHandler)
+			r.Get("/admin/jobs/{id}", app.showJobHandler)
+			r.Post("/admin/jobs/{id}/retry", app.retryJobHandler)
+		})
+
+		// Account lockout administration - admin only
+		r.Group(func(r chi.Router) {
+			r.Use(throttles["admin"], app.rateLimitGroup("admin"))
+			r.Use(app.requirePermission("lockouts:write"))
+			r.Get("/admin/lockouts", app.listLockoutsHandler)
+			r.Delete("/admin/lockouts/{id}", app.deleteLockoutHandler)
+		})
 	})
 
 	// Debug routes
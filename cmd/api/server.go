@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -23,14 +24,92 @@ func (app *application) serve() error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
+	// stopped is closed once the servers have shut down, stopping the
+	// certificate watcher and other housekeeping.
+	stopped := make(chan struct{})
+
+	var redirect *http.Server
+
+	if app.config.tls.certFile != "" {
+		certs, err := newCertReloader(app.config.tls.certFile, app.config.tls.keyFile, app.logger)
+		if err != nil {
+			return err
+		}
+
+		srv.TLSConfig, err = newTLSConfig(app.config, certs)
+		if err != nil {
+			return err
+		}
+
+		go certs.watch(app.config.tls.reloadInterval, stopped)
+
+		if app.config.tls.redirectPort != 0 {
+			redirect = &http.Server{
+				Addr:         fmt.Sprintf(":%d", app.config.tls.redirectPort),
+				Handler:      redirectToHTTPS(app.config.port),
+				IdleTimeout:  time.Minute,
+				ReadTimeout:  5 * time.Second,
+				WriteTimeout: 5 * time.Second,
+				ErrorLog:     srv.ErrorLog,
+			}
+
+			err = app.startAuxServer("HTTPS redirect", redirect)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Metrics are served on their own listener, which is not meant to be
+	// reachable from the internet, rather than alongside the public API.
+	var metrics *http.Server
+
+	if app.config.metrics.addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", app.instruments.handler())
+
+		metrics = &http.Server{
+			Addr:         app.config.metrics.addr,
+			Handler:      mux,
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			ErrorLog:     srv.ErrorLog,
+		}
+
+		err := app.startAuxServer("metrics", metrics)
+		if err != nil {
+			return err
+		}
+	}
+
 	shutdownError := make(chan error)
 
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for range hup {
+			app.logger.Info("reloading configuration")
+
+			err := app.reloadConfig()
+			if err != nil {
+				app.logger.Error("rejected configuration reload; keeping the current configuration", "error", err)
+			}
+		}
+	}()
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		s := <-quit
 		app.logger.Info("shutting down server", "addr", srv.Addr, "signal", s.String())
 
+		// Fail readiness checks first and give load balancers time to notice
+		// before the listener is closed.
+		app.draining.Store(true)
+		time.Sleep(app.config.shutdown.drainDelay)
+
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
@@ -39,15 +118,53 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
+		if redirect != nil {
+			err = redirect.Shutdown(ctx)
+			if err != nil {
+				app.logger.Error("unable to shut down HTTPS redirect server", "error", err)
+			}
+		}
+		if metrics != nil {
+			err = metrics.Shutdown(ctx)
+			if err != nil {
+				app.logger.Error("unable to shut down metrics server", "error", err)
+			}
+		}
+		close(stopped)
+
 		app.logger.Info("completing background tasks", "addr", srv.Addr)
 
 		app.wg.Wait()
+
+		err = app.queue.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
 		shutdownError <- nil
 	}()
 
-	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
+	app.queue.Start()
+	go app.purgeLoginFailures(stopped)
+	go app.rollupClientLogs(stopped)
+
+	// Waited for on shutdown so that the last API key usage is written.
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.flushKeyUsage(stopped)
+	}()
+
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env, "tls", srv.TLSConfig != nil)
 
-	err := srv.ListenAndServe()
+	var err error
+	if srv.TLSConfig != nil {
+		// The certificate comes from TLSConfig.GetCertificate.
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -60,3 +177,24 @@ func (app *application) serve() error {
 	app.logger.Info("stopped server", "addr", srv.Addr)
 	return nil
 }
+
+// startAuxServer starts one of the servers that run next to the API server.
+// It listens before returning so that a port clash stops startup rather than
+// going unnoticed.
+func (app *application) startAuxServer(name string, srv *http.Server) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		app.logger.Info("starting "+name+" server", "addr", srv.Addr)
+
+		err := srv.Serve(ln)
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Error(name+" server failed", "addr", srv.Addr, "error", err)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shadyar-bakr/greenlight/internal/data"
+	"github.com/shadyar-bakr/greenlight/internal/jobs"
+	"github.com/shadyar-bakr/greenlight/internal/mailer"
+)
+
+// newTestDB connects to the database named by GREENLIGHT_TEST_DB_DSN and
+// migrates it to the latest schema. Tests that need a database are skipped
+// when it isn't set. The database should be one set aside for tests.
+func newTestDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN not set")
+	}
+
+	db, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	err = migrateDB(context.Background(), db, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// newTestApplication builds an application on db with the default
+// configuration, adjusted by args, that captures email in memory.
+func newTestApplication(t *testing.T, db *pgxpool.Pool, args ...string) (*application, *mailer.MemoryTransport) {
+	t.Helper()
+
+	cfg, flags, err := loadConfig(append([]string{"-mail-transport=memory", "-limiter-enabled=false"}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := discardLogger()
+	models := data.NewModels(db, cfg.db.queryTimeout)
+	transport := mailer.NewMemoryTransport()
+
+	app := &application{
+		config:       cfg,
+		configValues: flagValues(flags),
+		logLevel:     new(slog.LevelVar),
+		logger:       logger,
+		db:           db,
+		models:       models,
+		instruments:  newInstruments(db),
+		limiter:      newMemoryLimiterStore(cfg.limiter.cleanup),
+		keyUsage:     newKeyUsage(),
+		roleCache:    newRoleCache(),
+		mailer:       mailer.New(transport, mailer.Config{BaseURL: cfg.mail.baseURL}),
+		queue: jobs.New(models.Jobs, logger, jobs.Config{
+			Workers:      1,
+			PollInterval: 10 * time.Millisecond,
+			LockTimeout:  time.Minute,
+			MaxBackoff:   time.Second,
+		}),
+	}
+
+	app.settings.Store(newSettings(cfg))
+	app.registerJobHandlers()
+
+	return app, transport
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
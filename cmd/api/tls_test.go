@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSignedCert generates a self-signed ECDSA certificate for localhost and
+// returns it PEM encoded with its key, along with the DER certificate.
+func selfSignedCert(t *testing.T) (certPEM, keyPEM, der []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err = x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, der
+}
+
+// writeCertFiles writes a certificate pair into dir with the given
+// modification time, which the reloader uses to notice changes.
+func writeCertFiles(t *testing.T, dir string, certPEM, keyPEM []byte, modTime time.Time) (certFile, keyFile string) {
+	t.Helper()
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+
+	for path, content := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		err := os.WriteFile(path, content, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(path, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return certFile, keyFile
+}
+
+// startTLSServer serves a handler reporting the negotiated protocol over TLS
+// configured the way serve does it, and returns its address and a client
+// certificate pool trusting it.
+func startTLSServer(t *testing.T, minVersion string, cipherSuites []string) (string, *x509.CertPool) {
+	t.Helper()
+
+	certPEM, keyPEM, der := selfSignedCert(t)
+	certFile, keyFile := writeCertFiles(t, t.TempDir(), certPEM, keyPEM, time.Now())
+
+	certs, err := newCertReloader(certFile, keyFile, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config
+	cfg.tls.minVersion = minVersion
+	cfg.tls.cipherSuites = cipherSuites
+
+	tlsConfig, err := newTLSConfig(cfg, certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+		TLSConfig: tlsConfig,
+		// Refused handshakes would otherwise be logged to stderr.
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		err := srv.ServeTLS(ln, "", "")
+		if !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("serve: %v", err)
+		}
+	}()
+	t.Cleanup(func() { srv.Close() })
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return ln.Addr().String(), pool
+}
+
+func TestTLSHandshake(t *testing.T) {
+	const (
+		ecdsaGCM    = "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"
+		ecdsaChaCha = "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"
+	)
+
+	suiteID := func(name string) uint16 {
+		ids, err := cipherSuiteIDs([]string{name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids[0]
+	}
+
+	tests := []struct {
+		name          string
+		minVersion    string
+		cipherSuites  []string
+		clientMax     uint16
+		clientSuites  []string
+		wantErr       bool
+		wantVersion   uint16
+		wantSuiteName string
+	}{
+		{
+			name:        "TLS 1.3 by default",
+			minVersion:  "1.2",
+			wantVersion: tls.VersionTLS13,
+		},
+		{
+			name:          "TLS 1.2 client gets a configured suite",
+			minVersion:    "1.2",
+			cipherSuites:  []string{ecdsaGCM},
+			clientMax:     tls.VersionTLS12,
+			wantVersion:   tls.VersionTLS12,
+			wantSuiteName: ecdsaGCM,
+		},
+		{
+			name:         "TLS 1.2 client without a configured suite is refused",
+			minVersion:   "1.2",
+			cipherSuites: []string{ecdsaGCM},
+			clientMax:    tls.VersionTLS12,
+			clientSuites: []string{ecdsaChaCha},
+			wantErr:      true,
+		},
+		{
+			name:       "TLS 1.2 client is refused when 1.3 is the minimum",
+			minVersion: "1.3",
+			clientMax:  tls.VersionTLS12,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, pool := startTLSServer(t, tt.minVersion, tt.cipherSuites)
+
+			clientConfig := &tls.Config{
+				RootCAs:    pool,
+				ServerName: "localhost",
+				MaxVersion: tt.clientMax,
+			}
+			for _, name := range tt.clientSuites {
+				clientConfig.CipherSuites = append(clientConfig.CipherSuites, suiteID(name))
+			}
+
+			conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr, clientConfig)
+			if tt.wantErr {
+				if err == nil {
+					conn.Close()
+					t.Fatal("handshake succeeded; want it refused")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			state := conn.ConnectionState()
+			if state.Version != tt.wantVersion {
+				t.Errorf("negotiated %s; want %s", tls.VersionName(state.Version), tls.VersionName(tt.wantVersion))
+			}
+			if tt.wantSuiteName != "" && state.CipherSuite != suiteID(tt.wantSuiteName) {
+				t.Errorf("negotiated %s; want %s", tls.CipherSuiteName(state.CipherSuite), tt.wantSuiteName)
+			}
+		})
+	}
+}
+
+func TestTLSNegotiatesHTTP2(t *testing.T) {
+	for _, suites := range [][]string{nil, http2CipherSuites} {
+		addr, pool := startTLSServer(t, "1.2", suites)
+
+		for _, clientMax := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+			client := &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig:   &tls.Config{RootCAs: pool, ServerName: "localhost", MaxVersion: clientMax},
+					ForceAttemptHTTP2: true,
+				},
+				Timeout: 5 * time.Second,
+			}
+
+			res, err := client.Get("https://" + addr + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			client.CloseIdleConnections()
+
+			if res.ProtoMajor != 2 || res.TLS.NegotiatedProtocol != "h2" {
+				t.Errorf("suites %v, max %s: got %s (ALPN %q); want HTTP/2", suites, tls.VersionName(clientMax), res.Proto, res.TLS.NegotiatedProtocol)
+			}
+		}
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+
+	certA, keyA, derA := selfSignedCert(t)
+	certFile, keyFile := writeCertFiles(t, dir, certA, keyA, start)
+
+	r, err := newCertReloader(certFile, keyFile, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertServing := func(want []byte, what string) {
+		t.Helper()
+
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(cert.Certificate[0], want) {
+			t.Errorf("not serving the %s certificate", what)
+		}
+	}
+
+	assertServing(derA, "original")
+
+	// Nothing has changed on disk.
+	loaded, err := r.reload()
+	if err != nil || loaded {
+		t.Fatalf("reload() = %t, %v with unchanged files; want false, nil", loaded, err)
+	}
+
+	// A renewed certificate is picked up.
+	certB, keyB, derB := selfSignedCert(t)
+	writeCertFiles(t, dir, certB, keyB, start.Add(time.Minute))
+
+	loaded, err = r.reload()
+	if err != nil || !loaded {
+		t.Fatalf("reload() = %t, %v with a replaced certificate; want true, nil", loaded, err)
+	}
+	assertServing(derB, "replaced")
+
+	// A certificate that doesn't match its key is rejected and the current
+	// one kept, as happens when the files are replaced one at a time.
+	certC, _, _ := selfSignedCert(t)
+	writeCertFiles(t, dir, certC, keyB, start.Add(2*time.Minute))
+
+	loaded, err = r.reload()
+	if err == nil || loaded {
+		t.Fatalf("reload() = %t, %v with a mismatched pair; want false and an error", loaded, err)
+	}
+	assertServing(derB, "previous")
+
+	// A missing file is an error too.
+	os.Remove(keyFile)
+
+	_, err = r.reload()
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("reload() error = %v with a missing key; want os.ErrNotExist", err)
+	}
+	assertServing(derB, "previous")
+}
+
+func TestNewCertReloaderRejectsMismatchedPair(t *testing.T) {
+	certA, _, _ := selfSignedCert(t)
+	_, keyB, _ := selfSignedCert(t)
+	certFile, keyFile := writeCertFiles(t, t.TempDir(), certA, keyB, time.Now())
+
+	_, err := newCertReloader(certFile, keyFile, discardLogger())
+	if err == nil {
+		t.Fatal("newCertReloader succeeded with a mismatched pair; want an error")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		httpsPort int
+		host      string
+		target    string
+		want      string
+	}{
+		{httpsPort: 443, host: "example.com", target: "/v1/movies?page=2", want: "https://example.com/v1/movies?page=2"},
+		{httpsPort: 443, host: "example.com:80", target: "/", want: "https://example.com/"},
+		{httpsPort: 8443, host: "example.com:8080", target: "/v1/healthcheck", want: "https://example.com:8443/v1/healthcheck"},
+		{httpsPort: 443, host: "[::1]:80", target: "/", want: "https://[::1]/"},
+		{httpsPort: 8443, host: "[::1]", target: "/x", want: "https://[::1]:8443/x"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+
+		redirectToHTTPS(tt.httpsPort).ServeHTTP(w, r)
+
+		if w.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s%s: got status %d; want %d", tt.host, tt.target, w.Code, http.StatusPermanentRedirect)
+		}
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s%s: redirected to %q; want %q", tt.host, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectListener(t *testing.T) {
+	ts := httptest.NewServer(redirectToHTTPS(8443))
+	defer ts.Close()
+
+	client := ts.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	// A POST must stay a POST, which is why the redirect is a 308.
+	res, err := client.Post(ts.URL+"/v1/tokens/authentication", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusPermanentRedirect {
+		t.Errorf("got status %d; want %d", res.StatusCode, http.StatusPermanentRedirect)
+	}
+
+	want := "https://127.0.0.1:8443/v1/tokens/authentication"
+	if got := res.Header.Get("Location"); got != want {
+		t.Errorf("redirected to %q; want %q", got, want)
+	}
+}
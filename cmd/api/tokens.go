@@ -33,20 +33,40 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	// Lookup the user record based on the email address. If no matching user was
-	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
-	// Unauthorized response to the client (we will create this helper in a moment).
+	if !app.allowLogin(w, r, input.Email) || app.tooManyLoginFailures(w, r) {
+		return
+	}
+
+	// Lookup the user record based on the email address. Failed attempts,
+	// including those for unknown emails, count towards the lockout.
 	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			if !app.loginTooSoon(w, r, 0) {
+				app.loginFailed(w, r, nil)
+			}
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	if app.loginTooSoon(w, r, user.ID) {
+		return
+	}
+
+	// A locked account can't log in even with the right password.
+	lockout, err := app.models.Lockouts.GetForUser(r.Context(), user.ID)
+	switch {
+	case err == nil:
+		app.accountLockedResponse(w, r, lockout.LockedUntil)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Check if the provided password matches the actual password for the user.
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
@@ -54,15 +74,44 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
-	// helper again and return.
 	if !match {
-		app.invalidCredentialsResponse(w, r)
+		app.loginFailed(w, r, user)
+		return
+	}
+
+	err = app.models.Lockouts.ClearFailures(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Users with two-factor authentication get a short-lived token instead,
+	// which they exchange along with a code at /v1/tokens/mfa.
+	enrollment, err := app.models.MFA.GetTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if enrollment != nil && enrollment.Confirmed {
+		mfaToken, err := app.models.Tokens.New(r.Context(), user.ID, mfaTokenTTL, data.ScopeMFA)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, envelope{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
 	// Generate both access and refresh tokens
-	accessToken, refreshToken, err := app.models.Tokens.NewPair(user.ID, 15*time.Minute, 24*time.Hour)
+	accessToken, refreshToken, err := app.models.Tokens.NewPair(r.Context(), user.ID, 15*time.Minute, 24*time.Hour)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -98,7 +147,7 @@ func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Verify and get the refresh token
-	refreshToken, err := app.models.Tokens.GetRefreshToken(input.RefreshToken)
+	refreshToken, err := app.models.Tokens.GetRefreshToken(r.Context(), input.RefreshToken)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrInvalidToken):
@@ -112,14 +161,14 @@ func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Generate a new token pair
-	accessToken, newRefreshToken, err := app.models.Tokens.NewPair(refreshToken.UserID, 15*time.Minute, 24*time.Hour)
+	accessToken, newRefreshToken, err := app.models.Tokens.NewPair(r.Context(), refreshToken.UserID, 15*time.Minute, 24*time.Hour)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	// Delete the old refresh token
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeRefresh, refreshToken.UserID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeRefresh, refreshToken.UserID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -1,20 +1,27 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/shadyar-bakr/greenlight/internal/data"
 	"github.com/shadyar-bakr/greenlight/internal/validator"
 )
 
 func (app *application) createTrustedClientHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name           string `json:"name"`
-		Description    string `json:"description"`
-		RateLimitRPS   int    `json:"rate_limit_rps"`
-		RateLimitBurst int    `json:"rate_limit_burst"`
+		Name           string     `json:"name"`
+		Description    string     `json:"description"`
+		RateLimitRPS   int        `json:"rate_limit_rps"`
+		RateLimitBurst int        `json:"rate_limit_burst"`
+		AllowedIPs     []string   `json:"allowed_ips"`
+		ExpiresAt      *time.Time `json:"expires_at"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -29,24 +36,20 @@ func (app *application) createTrustedClientHandler(w http.ResponseWriter, r *htt
 		RateLimitRPS:   input.RateLimitRPS,
 		RateLimitBurst: input.RateLimitBurst,
 		Enabled:        true,
+		ExpiresAt:      input.ExpiresAt,
 	}
 
 	v := validator.New()
 
-	if v.Valid() {
-		v.Check(input.Name != "", "name", "must be provided")
-		v.Check(len(input.Name) <= 500, "name", "must not be more than 500 bytes long")
-		v.Check(input.RateLimitRPS > 0, "rate_limit_rps", "must be greater than 0")
-		v.Check(input.RateLimitBurst > 0, "rate_limit_burst", "must be greater than 0")
-		v.Check(input.RateLimitBurst >= input.RateLimitRPS, "rate_limit_burst", "must be greater than or equal to rate_limit_rps")
-	}
+	client.AllowedIPs = readAllowedIPs(v, input.AllowedIPs)
+	v.Check(input.ExpiresAt == nil || input.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
 
-	if !v.Valid() {
+	if data.ValidateTrustedClient(v, client); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.TrustedClients.Insert(client)
+	err = app.models.TrustedClients.Insert(r.Context(), client)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -72,7 +75,7 @@ func (app *application) showTrustedClientHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	clients, err := app.models.TrustedClients.GetAll()
+	clients, err := app.models.TrustedClients.GetAll(r.Context())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -105,7 +108,7 @@ func (app *application) updateTrustedClientHandler(w http.ResponseWriter, r *htt
 	}
 
 	// Get the current client
-	clients, err := app.models.TrustedClients.GetAll()
+	clients, err := app.models.TrustedClients.GetAll(r.Context())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -125,11 +128,13 @@ func (app *application) updateTrustedClientHandler(w http.ResponseWriter, r *htt
 	}
 
 	var input struct {
-		Name           *string `json:"name"`
-		Description    *string `json:"description"`
-		RateLimitRPS   *int    `json:"rate_limit_rps"`
-		RateLimitBurst *int    `json:"rate_limit_burst"`
-		Enabled        *bool   `json:"enabled"`
+		Name           *string    `json:"name"`
+		Description    *string    `json:"description"`
+		RateLimitRPS   *int       `json:"rate_limit_rps"`
+		RateLimitBurst *int       `json:"rate_limit_burst"`
+		Enabled        *bool      `json:"enabled"`
+		AllowedIPs     []string   `json:"allowed_ips"`
+		ExpiresAt      *time.Time `json:"expires_at"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -160,20 +165,21 @@ func (app *application) updateTrustedClientHandler(w http.ResponseWriter, r *htt
 
 	v := validator.New()
 
-	if v.Valid() {
-		v.Check(client.Name != "", "name", "must be provided")
-		v.Check(len(client.Name) <= 500, "name", "must not be more than 500 bytes long")
-		v.Check(client.RateLimitRPS > 0, "rate_limit_rps", "must be greater than 0")
-		v.Check(client.RateLimitBurst > 0, "rate_limit_burst", "must be greater than 0")
-		v.Check(client.RateLimitBurst >= client.RateLimitRPS, "rate_limit_burst", "must be greater than or equal to rate_limit_rps")
+	if input.AllowedIPs != nil {
+		client.AllowedIPs = readAllowedIPs(v, input.AllowedIPs)
 	}
 
-	if !v.Valid() {
+	if input.ExpiresAt != nil {
+		client.ExpiresAt = input.ExpiresAt
+		v.Check(input.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+
+	if data.ValidateTrustedClient(v, client); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.TrustedClients.Update(client)
+	err = app.models.TrustedClients.Update(r.Context(), client)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -197,7 +203,7 @@ func (app *application) deleteTrustedClientHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
-	err = app.models.TrustedClients.Delete(id)
+	err = app.models.TrustedClients.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -215,7 +221,7 @@ func (app *application) deleteTrustedClientHandler(w http.ResponseWriter, r *htt
 }
 
 func (app *application) listTrustedClientsHandler(w http.ResponseWriter, r *http.Request) {
-	clients, err := app.models.TrustedClients.GetAll()
+	clients, err := app.models.TrustedClients.GetAll(r.Context())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -227,6 +233,9 @@ func (app *application) listTrustedClientsHandler(w http.ResponseWriter, r *http
 	}
 }
 
+// regenerateAPIKeyHandler creates a new API key for a trusted client. The
+// previous keys keep working for the configured grace period so that
+// consumers can switch over without downtime.
 func (app *application) regenerateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -234,7 +243,7 @@ func (app *application) regenerateAPIKeyHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	apiKey, err := app.models.TrustedClients.RegenerateAPIKey(id)
+	key, err := app.models.TrustedClientKeys.New(r.Context(), id, nil, app.config.clients.keyGracePeriod)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -246,10 +255,350 @@ func (app *application) regenerateAPIKeyHandler(w http.ResponseWriter, r *http.R
 	}
 
 	err = app.writeJSON(w, http.StatusOK, envelope{
-		"message": "API key successfully regenerated",
-		"api_key": apiKey,
+		"message": fmt.Sprintf("API key successfully regenerated; previous keys stop working in %s", app.config.clients.keyGracePeriod),
+		"api_key": key.APIKey,
 	}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) listTrustedClientKeysHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.TrustedClients.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	keys, err := app.models.TrustedClientKeys.GetAllForClient(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createTrustedClientKeyHandler creates a new API key for a trusted client,
+// optionally expiring. The client's other keys keep working for the grace
+// period, which defaults to -client-key-grace-period, and then expire.
+func (app *application) createTrustedClientKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ExpiresAt   *time.Time `json:"expires_at"`
+		GracePeriod *string    `json:"grace_period"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	grace := app.config.clients.keyGracePeriod
+
+	v := validator.New()
+
+	if input.GracePeriod != nil {
+		grace, err = time.ParseDuration(*input.GracePeriod)
+		v.Check(err == nil && grace >= 0, "grace_period", "must be a duration such as 24h or 0s")
+	}
+	v.Check(input.ExpiresAt == nil || input.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key, err := app.models.TrustedClientKeys.New(r.Context(), id, input.ExpiresAt, grace)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/trusted-clients/%d/keys/%d", id, key.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{
+		"key":     key,
+		"message": "Store the API key securely as it won't be shown again",
+	}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeTrustedClientKeyHandler deletes one of a trusted client's API keys,
+// which stops working immediately.
+func (app *application) revokeTrustedClientKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	keyID, err := strconv.ParseInt(chi.URLParam(r, "keyID"), 10, 64)
+	if err != nil || keyID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.TrustedClientKeys.Revoke(r.Context(), id, keyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "API key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showTrustedClientPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.TrustedClients.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForClient(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateTrustedClientPermissionsHandler sets the permissions a trusted client
+// may request as OAuth scopes, replacing the current ones.
+func (app *application) updateTrustedClientPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Permissions != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range input.Permissions {
+		v.Check(known.Include(code), "permissions", fmt.Sprintf("unknown permission %q", code))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.TrustedClients.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Permissions.SetForClient(r.Context(), id, input.Permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": input.Permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAllowedIPs parses the CIDR prefixes or IP addresses of a trusted client's
+// allowlist, recording any that are invalid in v.
+func readAllowedIPs(v *validator.Validator, values []string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(values))
+
+	for _, value := range values {
+		prefix, err := parsePrefix(value)
+		if err != nil {
+			v.AddError("allowed_ips", err.Error())
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes
+}
+
+// trustedClientUsageHandler reports a trusted client's requests between from
+// and to, which default to the last week, in hourly or daily buckets along
+// with the endpoints it used most.
+func (app *application) trustedClientUsageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	to := app.readTime(qs, "to", time.Now(), v)
+	from := app.readTime(qs, "from", to.Add(-7*24*time.Hour), v)
+	interval := app.readString(qs, "interval", "day")
+	top := app.readInt(qs, "top", 10, v)
+
+	if data.ValidateUsageQuery(v, from, to, interval, top); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.TrustedClients.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	usage, err := app.models.TrustedClients.Usage(r.Context(), id, from, to, interval, top)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"from":          from,
+		"to":            to,
+		"interval":      interval,
+		"buckets":       usage.Buckets,
+		"top_endpoints": usage.TopEndpoints,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// clientLogRollupBatchSize is how many trusted client requests are rolled up
+// by each statement.
+const clientLogRollupBatchSize = 10_000
+
+// rollupClientLogs rolls trusted client requests older than the log retention
+// into daily totals every hour, and deletes totals older than the usage
+// retention if there is one, until done is closed.
+func (app *application) rollupClientLogs(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			now := time.Now()
+
+			n, err := app.rollupClientLogBatches(now.Add(-app.config.clients.logRetention), done)
+			if err != nil {
+				app.logger.Error("unable to roll up trusted client logs", "error", err)
+			}
+			if n > 0 {
+				app.logger.Info("rolled up trusted client logs", "rows", n)
+			}
+
+			if app.config.clients.usageRetention > 0 {
+				_, err = app.models.TrustedClients.DeleteUsageBefore(context.Background(), now.Add(-app.config.clients.usageRetention))
+				if err != nil {
+					app.logger.Error("unable to delete old trusted client usage", "error", err)
+				}
+			}
+		}
+	}
+}
+
+// rollupClientLogBatches rolls up the trusted client requests from before t a
+// batch at a time, stopping early if done is closed. It returns how many were
+// rolled up.
+func (app *application) rollupClientLogBatches(t time.Time, done <-chan struct{}) (int64, error) {
+	var total int64
+
+	for {
+		n, err := app.models.TrustedClients.RollupLogs(context.Background(), t, clientLogRollupBatchSize)
+		total += n
+		if err != nil || n < clientLogRollupBatchSize {
+			return total, err
+		}
+
+		select {
+		case <-done:
+			return total, nil
+		default:
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shadyar-bakr/greenlight/internal/data"
+)
+
+func TestKeyRotationGrace(t *testing.T) {
+	db := newTestDB(t)
+	models := data.NewModels(db, 3*time.Second)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// oldExpiry, if set, is when the old key was already due to expire.
+		oldExpiry   time.Duration
+		grace       time.Duration
+		wantOldWork bool
+		// wantOldExpiry is roughly when the old key should now expire.
+		wantOldExpiry time.Duration
+	}{
+		{name: "no grace period", grace: 0, wantOldWork: false},
+		{name: "grace period", grace: time.Hour, wantOldWork: true, wantOldExpiry: time.Hour},
+		{name: "earlier expiry is kept", oldExpiry: 10 * time.Minute, grace: time.Hour, wantOldWork: true, wantOldExpiry: 10 * time.Minute},
+		{name: "later expiry is brought forward", oldExpiry: 48 * time.Hour, grace: time.Hour, wantOldWork: true, wantOldExpiry: time.Hour},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &data.TrustedClient{
+				Name:           fmt.Sprintf("rotation-%d-%d", time.Now().UnixNano(), i),
+				RateLimitRPS:   1,
+				RateLimitBurst: 1,
+				Enabled:        true,
+			}
+			err := models.TrustedClients.Insert(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { models.TrustedClients.Delete(ctx, client.ID) })
+
+			oldKey := client.APIKey
+			if tt.oldExpiry > 0 {
+				expiresAt := time.Now().Add(tt.oldExpiry)
+				key, err := models.TrustedClientKeys.New(ctx, client.ID, &expiresAt, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				oldKey = key.APIKey
+			}
+
+			newKey, err := models.TrustedClientKeys.New(ctx, client.ID, nil, tt.grace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := models.TrustedClients.GetByAPIKey(ctx, newKey.APIKey)
+			if err != nil || got.KeyID != newKey.ID {
+				t.Fatalf("new key: got %v, %v; want key %d", got, err, newKey.ID)
+			}
+
+			got, err = models.TrustedClients.GetByAPIKey(ctx, oldKey)
+			switch {
+			case !tt.wantOldWork:
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					t.Errorf("old key: got %v, %v; want ErrRecordNotFound", got, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("old key: %v", err)
+			}
+
+			keys, err := models.TrustedClientKeys.GetAllForClient(ctx, client.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, key := range keys {
+				if key.ID != got.KeyID {
+					continue
+				}
+				found = true
+				want := time.Now().Add(tt.wantOldExpiry)
+				if key.ExpiresAt == nil || key.ExpiresAt.Sub(want).Abs() > time.Minute {
+					t.Errorf("old key expires at %v; want about %v", key.ExpiresAt, want)
+				}
+			}
+			if !found {
+				t.Errorf("old key %d missing from the client's keys", got.KeyID)
+			}
+		})
+	}
+}
+
+func TestRejectedClientRequestsAreLogged(t *testing.T) {
+	db := newTestDB(t)
+	app, _ := newTestApplication(t, db, "-limiter-enabled=true")
+	ctx := context.Background()
+
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	newClient := func(name string, expiresAt *time.Time) *data.TrustedClient {
+		client := &data.TrustedClient{
+			Name:           fmt.Sprintf("%s-%d", name, time.Now().UnixNano()),
+			RateLimitRPS:   1,
+			RateLimitBurst: 1,
+			Enabled:        true,
+			ExpiresAt:      expiresAt,
+		}
+		err := app.models.TrustedClients.Insert(ctx, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { app.models.TrustedClients.Delete(ctx, client.ID) })
+		return client
+	}
+
+	get := func(apiKey string) int {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/movies", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-API-Key", apiKey)
+
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	limited := newClient("limited", nil)
+	expiry := time.Now().Add(-time.Hour)
+	expired := newClient("expired", &expiry)
+
+	// The limited client has no permissions, so its first request is
+	// refused by the handler and the second by the rate limiter.
+	wantStatuses := map[int64][]int{
+		limited.ID: {http.StatusForbidden, http.StatusTooManyRequests},
+		expired.ID: {http.StatusUnauthorized},
+	}
+	for _, client := range []*data.TrustedClient{limited, expired} {
+		for _, want := range wantStatuses[client.ID] {
+			if got := get(client.APIKey); got != want {
+				t.Fatalf("client %d: got status %d; want %d", client.ID, got, want)
+			}
+		}
+	}
+
+	app.wg.Wait()
+
+	for clientID, want := range wantStatuses {
+		rows, err := db.Query(ctx, `
+			SELECT endpoint, status_code FROM trusted_client_logs
+			WHERE client_id = $1 ORDER BY id`, clientID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []int
+		for rows.Next() {
+			var (
+				endpoint string
+				status   int
+			)
+			err := rows.Scan(&endpoint, &status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if endpoint != "/v1/movies" {
+				t.Errorf("client %d: logged endpoint %q; want /v1/movies", clientID, endpoint)
+			}
+			got = append(got, status)
+		}
+		rows.Close()
+
+		if !slices.Equal(got, want) {
+			t.Errorf("client %d: logged statuses %v; want %v", clientID, got, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shadyar-bakr/greenlight/internal/mailer"
+)
+
+var activationTokenRX = regexp.MustCompile(`\{"token": "([A-Z2-7]{26})"\}`)
+
+func TestRegisterUserSendsActivationToken(t *testing.T) {
+	db := newTestDB(t)
+	app, transport := newTestApplication(t, db)
+
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	email := fmt.Sprintf("alice-%d@example.com", time.Now().UnixNano())
+	body := fmt.Sprintf(`{"name": "Alice", "email": %q, "password": "pa55word1234"}`, email)
+
+	res, err := ts.Client().Post(ts.URL+"/v1/users", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("got status %d registering; want %d", res.StatusCode, http.StatusAccepted)
+	}
+
+	// The welcome email is sent by a job queued with the user.
+	app.queue.Start()
+	defer app.queue.Shutdown(context.Background())
+
+	msg := waitForMessage(t, transport, email)
+
+	if msg.Template != "user_welcome.tmpl" {
+		t.Errorf("got template %q; want %q", msg.Template, "user_welcome.tmpl")
+	}
+
+	match := activationTokenRX.FindStringSubmatch(msg.PlainBody)
+	if match == nil {
+		t.Fatalf("no activation token in message body:\n%s", msg.PlainBody)
+	}
+	if !strings.Contains(msg.HTMLBody, match[1]) {
+		t.Errorf("activation token %q missing from the HTML body", match[1])
+	}
+
+	// The token must be the one that actually activates the account.
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/v1/users/activated", strings.NewReader(`{"token": "`+match[1]+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err = ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("got status %d activating with the emailed token; want %d", res.StatusCode, http.StatusOK)
+	}
+}
+
+// waitForMessage waits for a message to be sent to recipient.
+func waitForMessage(t *testing.T, transport *mailer.MemoryTransport, recipient string) mailer.Message {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, msg := range transport.Messages() {
+			if msg.To == recipient {
+				return msg
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("no message sent to %s", recipient)
+	return mailer.Message{}
+}
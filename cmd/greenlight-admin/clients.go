@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shadyar-bakr/greenlight/internal/data"
+)
+
+func listClients(ctx context.Context, models data.Models, args []string) error {
+	fs := newFlagSet("client list")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	clients, err := models.TrustedClients.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tRPS\tBURST\tENABLED\tCREATED")
+	for _, c := range clients {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%t\t%s\n", c.ID, c.Name, c.RateLimitRPS, c.RateLimitBurst, c.Enabled, c.CreatedAt.Format("2006-01-02"))
+	}
+	return tw.Flush()
+}
+
+func createClient(ctx context.Context, models data.Models, args []string) error {
+	fs := newFlagSet("client create")
+	name := fs.String("name", "", "Client name")
+	description := fs.String("description", "", "Client description")
+	rps := fs.Int("rps", 1000, "Rate limit in requests per second")
+	burst := fs.Int("burst", 2000, "Rate limit burst")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if err := required(fs, "name"); err != nil {
+		return err
+	}
+
+	if *rps < 1 || *burst < 1 {
+		return errors.New("-rps and -burst must be positive")
+	}
+
+	client := &data.TrustedClient{
+		Name:           *name,
+		Description:    *description,
+		RateLimitRPS:   *rps,
+		RateLimitBurst: *burst,
+		Enabled:        true,
+	}
+
+	err = models.TrustedClients.Insert(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("created trusted client %d (%s)\n", client.ID, client.Name)
+	printAPIKey(client.APIKey)
+	return nil
+}
+
+func rotateClientKey(ctx context.Context, models data.Models, args []string) error {
+	fs := newFlagSet("client rotate")
+	id := fs.Int64("id", 0, "Client ID")
+	grace := fs.Duration("grace", 24*time.Hour, "How long the previous keys keep working")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *id < 1 {
+		return errors.New("-id is required")
+	}
+
+	if *grace < 0 {
+		return errors.New("-grace must not be negative")
+	}
+
+	key, err := models.TrustedClientKeys.New(ctx, *id, nil, *grace)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fmt.Errorf("no trusted client with id %d", *id)
+		}
+		return err
+	}
+
+	if *grace == 0 {
+		fmt.Printf("rotated API key for trusted client %d; the previous keys no longer work\n", *id)
+	} else {
+		fmt.Printf("rotated API key for trusted client %d; the previous keys stop working in %s\n", *id, *grace)
+	}
+	printAPIKey(key.APIKey)
+	return nil
+}
+
+func purgeTokens(ctx context.Context, models data.Models, args []string) error {
+	fs := newFlagSet("tokens purge")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	count, err := models.Tokens.DeleteExpired(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("deleted %d expired token(s)\n", count)
+	return nil
+}
+
+func printAPIKey(apiKey string) {
+	fmt.Printf("\nAPI key: %s\n\nStore it now; it is only stored hashed and cannot be shown again.\n", apiKey)
+}
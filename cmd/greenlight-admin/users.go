@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/shadyar-bakr/greenlight/internal/data"
+	"github.com/shadyar-bakr/greenlight/internal/validator"
+)
+
+func createUser(ctx context.Context, models data.Models, args []string) error {
+	fs := newFlagSet("user create")
+	email := fs.String("email", "", "Email address")
+	name := fs.String("name", "", "Display name")
+	locale := fs.String("locale", "en", "Locale for emails")
+	activated := fs.Bool("activated", false, "Create the user already activated")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if err := required(fs, "email", "name"); err != nil {
+		return err
+	}
+
+	// Read the password from stdin so that it doesn't end up in the shell
+	// history or the process list.
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("unable to read password: %w", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+
+	user := &data.User{
+		Name:      *name,
+		Email:     *email,
+		Locale:    *locale,
+		Activated: *activated,
+	}
+
+	err = user.Password.Set(password)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		return validationError(v)
+	}
+
+	err = models.Transaction(ctx, func(tx data.Models) error {
+		err := tx.Users.Insert(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Permissions.AddForUser(ctx, user.ID, "movies:read")
+	})
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			return fmt.Errorf("a user with email %s already exists", *email)
+		}
+		return err
+	}
+
+	fmt.Printf("created user %d <%s> (activated: %t)\n", user.ID, user.Email, user.Activated)
+	return nil
+}
+
+func activateUser(ctx context.Context, models data.Models, args []string) error {
+	fs := newFlagSet("user activate")
+	email := fs.String("email", "", "Email address")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if err := required(fs, "email"); err != nil {
+		return err
+	}
+
+	user, err := getUser(ctx, models, *email)
+	if err != nil {
+		return err
+	}
+
+	if user.Activated {
+		fmt.Printf("user %d <%s> is already activated\n", user.ID, user.Email)
+		return nil
+	}
+
+	user.Activated = true
+
+	err = models.Users.Update(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	err = models.Tokens.DeleteAllForUser(ctx, data.ScopeActivation, user.ID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("activated user %d <%s>\n", user.ID, user.Email)
+	return nil
+}
+
+func listPermissions(ctx context.Context, models data.Models, args []string) error {
+	fs := newFlagSet("permission list")
+	email := fs.String("email", "", "Only list the effective permissions of this user")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var permissions data.Permissions
+
+	if *email == "" {
+		permissions, err = models.Permissions.GetAll(ctx)
+	} else {
+		var user *data.User
+		user, err = getUser(ctx, models, *email)
+		if err != nil {
+			return err
+		}
+		permissions, err = models.Permissions.GetAllForUser(ctx, user.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, code := range permissions {
+		fmt.Println(code)
+	}
+	return nil
+}
+
+func grantPermissions(ctx context.Context, models data.Models, args []string) error {
+	user, codes, err := parsePermissionArgs(ctx, models, "permission grant", args)
+	if err != nil {
+		return err
+	}
+
+	err = models.Permissions.AddForUser(ctx, user.ID, codes...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("granted %s to user %d <%s>\n", strings.Join(codes, ", "), user.ID, user.Email)
+	return nil
+}
+
+func revokePermissions(ctx context.Context, models data.Models, args []string) error {
+	user, codes, err := parsePermissionArgs(ctx, models, "permission revoke", args)
+	if err != nil {
+		return err
+	}
+
+	err = models.Permissions.RemoveForUser(ctx, user.ID, codes...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("revoked %s from user %d <%s>\n", strings.Join(codes, ", "), user.ID, user.Email)
+	return nil
+}
+
+// parsePermissionArgs parses -email followed by one or more permission codes,
+// checking that every code exists.
+func parsePermissionArgs(ctx context.Context, models data.Models, name string, args []string) (*data.User, []string, error) {
+	fs := newFlagSet(name)
+	email := fs.String("email", "", "Email address")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := required(fs, "email"); err != nil {
+		return nil, nil, err
+	}
+
+	codes := fs.Args()
+	if len(codes) == 0 {
+		return nil, nil, errors.New("at least one permission code is required")
+	}
+
+	known, err := models.Permissions.GetAll(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, code := range codes {
+		if !known.Include(code) {
+			return nil, nil, fmt.Errorf("unknown permission %q (known: %s)", code, strings.Join(known, ", "))
+		}
+	}
+
+	user, err := getUser(ctx, models, *email)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, slices.Compact(slices.Sorted(slices.Values(codes))), nil
+}
+
+func grantRole(ctx context.Context, models data.Models, args []string) error {
+	user, role, err := parseRoleArgs(ctx, models, "role grant", args)
+	if err != nil {
+		return err
+	}
+
+	err = models.Roles.AssignToUser(ctx, user.ID, role.ID, 0)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("granted role %s to user %d <%s>\n", role.Name, user.ID, user.Email)
+	return nil
+}
+
+func revokeRole(ctx context.Context, models data.Models, args []string) error {
+	user, role, err := parseRoleArgs(ctx, models, "role revoke", args)
+	if err != nil {
+		return err
+	}
+
+	err = models.Roles.UnassignFromUser(ctx, user.ID, role.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fmt.Errorf("user %d <%s> does not have role %s", user.ID, user.Email, role.Name)
+		}
+		return err
+	}
+
+	fmt.Printf("revoked role %s from user %d <%s>\n", role.Name, user.ID, user.Email)
+	return nil
+}
+
+func parseRoleArgs(ctx context.Context, models data.Models, name string, args []string) (*data.User, *data.Role, error) {
+	fs := newFlagSet(name)
+	email := fs.String("email", "", "Email address")
+	roleName := fs.String("role", "", "Role name")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := required(fs, "email", "role"); err != nil {
+		return nil, nil, err
+	}
+
+	user, err := getUser(ctx, models, *email)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	role, err := models.Roles.GetByName(ctx, *roleName)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil, fmt.Errorf("no role named %q", *roleName)
+		}
+		return nil, nil, err
+	}
+
+	return user, role, nil
+}
+
+func getUser(ctx context.Context, models data.Models, email string) (*data.User, error) {
+	user, err := models.Users.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no user with email %s", email)
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func validationError(v *validator.Validator) error {
+	var errs []error
+	for field, message := range v.Errors {
+		errs = append(errs, fmt.Errorf("%s: %s", field, message))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,300 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shadyar-bakr/greenlight/internal/validator"
+)
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusDead      = "dead"
+)
+
+// Job is a unit of background work persisted in the jobs table. The payload is
+// never serialized in API responses since it may carry secrets such as
+// activation tokens.
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"-"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	LastError   *string         `json:"last_error,omitempty"`
+	RunAt       time.Time       `json:"run_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+func ValidateJobStatus(v *validator.Validator, status string) {
+	v.Check(validator.PermittedValue(status, "", JobStatusQueued, JobStatusRunning, JobStatusSucceeded, JobStatusDead), "status", "invalid status value")
+}
+
+type JobModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+const jobColumns = `id, kind, payload, status, attempts, max_attempts, last_error, run_at, created_at, updated_at`
+
+func scanJob(row pgx.Row, job *Job) error {
+	return row.Scan(
+		&job.ID,
+		&job.Kind,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.LastError,
+		&job.RunAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+}
+
+// Insert enqueues a job. A zero RunAt means the job is runnable immediately.
+func (m JobModel) Insert(ctx context.Context, job *Job) error {
+	query := `
+		INSERT INTO jobs (kind, payload, max_attempts, run_at)
+		VALUES ($1, $2, $3, COALESCE($4, NOW()))
+		RETURNING ` + jobColumns
+
+	var runAt *time.Time
+	if !job.RunAt.IsZero() {
+		runAt = &job.RunAt
+	}
+
+	args := []any{job.Kind, job.Payload, job.MaxAttempts, runAt}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return scanJob(m.DB.QueryRow(ctx, query, args...), job)
+}
+
+// Dequeue claims the oldest runnable job and marks it as running. Jobs left
+// running for longer than lockTimeout (because the worker holding them died)
+// are considered abandoned and are claimed again. Concurrent workers never
+// receive the same job thanks to SKIP LOCKED. If no job is runnable,
+// ErrRecordNotFound is returned.
+func (m JobModel) Dequeue(ctx context.Context, lockTimeout time.Duration) (*Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, locked_at = NOW(), updated_at = NOW()
+		WHERE id = (
+			SELECT id
+			FROM jobs
+			WHERE (status = 'queued' AND run_at <= NOW())
+			OR (status = 'running' AND locked_at < NOW() - make_interval(secs => $1))
+			ORDER BY run_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + jobColumns
+
+	var job Job
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := scanJob(m.DB.QueryRow(ctx, query, lockTimeout.Seconds()), &job)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// Backlog returns the number of queued jobs that are due to run and how long
+// the oldest of them has been waiting.
+func (m JobModel) Backlog(ctx context.Context) (int, time.Duration, error) {
+	query := `
+		SELECT count(*), COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(run_at)), 0)
+		FROM jobs
+		WHERE status = 'queued' AND run_at <= NOW()`
+
+	var (
+		count int
+		age   float64
+	)
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query).Scan(&count, &age)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return count, time.Duration(age * float64(time.Second)), nil
+}
+
+// Complete marks a job as succeeded and clears its payload so that secrets
+// carried by the job don't linger in the database.
+func (m JobModel) Complete(ctx context.Context, id int64, attempt int) error {
+	query := `
+		UPDATE jobs
+		SET status = 'succeeded', payload = '{}', last_error = NULL, locked_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND attempts = $2 AND status = 'running'`
+
+	return m.finish(ctx, query, id, attempt)
+}
+
+// Fail records a failed attempt and schedules the job to run again at runAt.
+func (m JobModel) Fail(ctx context.Context, id int64, attempt int, lastError string, runAt time.Time) error {
+	query := `
+		UPDATE jobs
+		SET status = 'queued', last_error = $3, run_at = $4, locked_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND attempts = $2 AND status = 'running'`
+
+	return m.finish(ctx, query, id, attempt, lastError, runAt)
+}
+
+// Bury moves a job to the dead-letter state, where it stays until retried.
+func (m JobModel) Bury(ctx context.Context, id int64, attempt int, lastError string) error {
+	query := `
+		UPDATE jobs
+		SET status = 'dead', last_error = $3, locked_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND attempts = $2 AND status = 'running'`
+
+	return m.finish(ctx, query, id, attempt, lastError)
+}
+
+// finish records the outcome of a job attempt, but only if it is still the
+// running attempt. It returns ErrEditConflict if the job's lock timed out and
+// it was reclaimed in the meantime, in which case the newer attempt decides
+// the outcome.
+func (m JobModel) finish(ctx context.Context, query string, args ...any) error {
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// Retry requeues a dead job with a fresh set of attempts.
+func (m JobModel) Retry(ctx context.Context, id int64) (*Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'queued', attempts = 0, run_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = 'dead'
+		RETURNING ` + jobColumns
+
+	var job Job
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := scanJob(m.DB.QueryRow(ctx, query, id), &job)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+func (m JobModel) Get(ctx context.Context, id int64) (*Job, error) {
+	query := `
+		SELECT ` + jobColumns + `
+		FROM jobs
+		WHERE id = $1`
+
+	var job Job
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := scanJob(m.DB.QueryRow(ctx, query, id), &job)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// GetAll lists jobs, optionally restricted to a single status and kind.
+func (m JobModel) GetAll(ctx context.Context, status string, kind string, filters Filters) ([]*Job, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+			FROM jobs
+			WHERE (status = $1 OR $1 = '')
+			AND (kind = $2 OR $2 = '')
+			ORDER BY %s %s, id ASC
+			LIMIT $3 OFFSET $4`,
+		jobColumns, filters.GetSortColumn(), filters.GetSortDirection())
+
+	args := []any{status, kind, filters.Getlimit(), filters.Getoffset()}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	jobs := []*Job{}
+
+	for rows.Next() {
+		var job Job
+
+		err := rows.Scan(
+			&totalRecords,
+			&job.ID,
+			&job.Kind,
+			&job.Payload,
+			&job.Status,
+			&job.Attempts,
+			&job.MaxAttempts,
+			&job.LastError,
+			&job.RunAt,
+			&job.CreatedAt,
+			&job.UpdatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		jobs = append(jobs, &job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return jobs, metadata, nil
+}
@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Lockout is an account temporarily locked after too many failed logins.
+type Lockout struct {
+	UserID      int64     `json:"user_id"`
+	Email       string    `json:"email"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type LockoutModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// RecordFailure records a failed login from ip and when the account and IP
+// may next try to log in. userID is zero when the email didn't match an
+// account, ip is empty when the client's IP is unknown and nextAttempt is the
+// zero time when failed logins aren't delayed.
+func (m LockoutModel) RecordFailure(ctx context.Context, userID int64, ip string, nextAttempt time.Time) error {
+	query := `
+		INSERT INTO login_failures (user_id, ip, next_attempt_at)
+		VALUES (NULLIF($1, 0), NULLIF($2, '')::inet, $3)`
+
+	var next *time.Time
+	if !nextAttempt.IsZero() {
+		next = &nextAttempt
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, userID, ip, next)
+	return err
+}
+
+// CountFailures returns the number of failed logins since the given time
+// against the account and from the IP address. ip is empty when the client's
+// IP is unknown, in which case no failures are counted against it.
+func (m LockoutModel) CountFailures(ctx context.Context, userID int64, ip string, since time.Time) (int, int, error) {
+	query := `
+		SELECT
+			count(*) FILTER (WHERE user_id = $1),
+			count(*) FILTER (WHERE ip = NULLIF($2, '')::inet)
+		FROM login_failures
+		WHERE (user_id = $1 OR ip = NULLIF($2, '')::inet) AND created_at >= $3`
+
+	var userFailures, ipFailures int
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, userID, ip, since).Scan(&userFailures, &ipFailures)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return userFailures, ipFailures, nil
+}
+
+// NextAttempt returns the time before which the account or IP may not try to
+// log in again after a failed login, or the zero time if they may try now.
+// userID is zero or ip empty to check only the other.
+func (m LockoutModel) NextAttempt(ctx context.Context, userID int64, ip string) (time.Time, error) {
+	query := `
+		SELECT max(next_attempt_at)
+		FROM login_failures
+		WHERE (user_id = $1 OR ip = NULLIF($2, '')::inet) AND next_attempt_at > NOW()`
+
+	var next *time.Time
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, userID, ip).Scan(&next)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if next == nil {
+		return time.Time{}, nil
+	}
+
+	return *next, nil
+}
+
+// ClearFailures forgets the failed logins against an account, for example
+// after a successful login.
+func (m LockoutModel) ClearFailures(ctx context.Context, userID int64) error {
+	query := `DELETE FROM login_failures WHERE user_id = $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, userID)
+	return err
+}
+
+// DeleteFailuresBefore removes failed logins older than t and returns how many
+// were deleted.
+func (m LockoutModel) DeleteFailuresBefore(ctx context.Context, t time.Time) (int64, error) {
+	query := `DELETE FROM login_failures WHERE created_at < $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, t)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// Lock locks an account until the given time, replacing any existing lockout.
+func (m LockoutModel) Lock(ctx context.Context, userID int64, failures int, until time.Time) error {
+	query := `
+		INSERT INTO account_lockouts (user_id, failures, locked_until)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET failures = EXCLUDED.failures, locked_until = EXCLUDED.locked_until, created_at = NOW()`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, userID, failures, until)
+	return err
+}
+
+// GetForUser returns the account's lockout, or ErrRecordNotFound if the
+// account isn't locked.
+func (m LockoutModel) GetForUser(ctx context.Context, userID int64) (*Lockout, error) {
+	query := `
+		SELECT account_lockouts.user_id, users.email, account_lockouts.failures, account_lockouts.locked_until, account_lockouts.created_at
+		FROM account_lockouts
+		INNER JOIN users ON users.id = account_lockouts.user_id
+		WHERE account_lockouts.user_id = $1 AND account_lockouts.locked_until > NOW()`
+
+	var lockout Lockout
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, userID).Scan(
+		&lockout.UserID,
+		&lockout.Email,
+		&lockout.Failures,
+		&lockout.LockedUntil,
+		&lockout.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &lockout, nil
+}
+
+// GetAll returns the accounts that are currently locked, soonest to unlock
+// first.
+func (m LockoutModel) GetAll(ctx context.Context) ([]*Lockout, error) {
+	query := `
+		SELECT account_lockouts.user_id, users.email, account_lockouts.failures, account_lockouts.locked_until, account_lockouts.created_at
+		FROM account_lockouts
+		INNER JOIN users ON users.id = account_lockouts.user_id
+		WHERE account_lockouts.locked_until > NOW()
+		ORDER BY account_lockouts.locked_until, account_lockouts.user_id`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []*Lockout{}
+
+	for rows.Next() {
+		var lockout Lockout
+
+		err := rows.Scan(
+			&lockout.UserID,
+			&lockout.Email,
+			&lockout.Failures,
+			&lockout.LockedUntil,
+			&lockout.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		lockouts = append(lockouts, &lockout)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lockouts, nil
+}
+
+// Unlock removes the account's lockout together with its failed logins, so
+// the next failure starts counting from zero. It returns ErrRecordNotFound if
+// the account wasn't locked.
+func (m LockoutModel) Unlock(ctx context.Context, userID int64) error {
+	query := `
+		WITH failures AS (
+			DELETE FROM login_failures WHERE user_id = $1
+		)
+		DELETE FROM account_lockouts
+		WHERE user_id = $1 AND locked_until > NOW()`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shadyar-bakr/greenlight/internal/validator"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrCodeReused        = errors.New("code has already been used")
+)
+
+// recoveryCodeCount is how many recovery codes a user is given at a time.
+const recoveryCodeCount = 10
+
+// TOTP is a user's TOTP enrollment. It only protects logins once confirmed
+// with a code, which proves the user's authenticator app has the secret.
+type TOTP struct {
+	UserID       int64
+	Secret       string
+	Confirmed    bool
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
+
+type MFAModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// CreateTOTP starts a TOTP enrollment with the given secret, replacing any
+// unconfirmed one. It returns ErrMFAAlreadyEnabled if the user already has a
+// confirmed enrollment.
+func (m MFAModel) CreateTOTP(ctx context.Context, userID int64, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_totp.confirmed = false`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// GetTOTP returns the user's TOTP enrollment, confirmed or not, or
+// ErrRecordNotFound if there is none.
+func (m MFAModel) GetTOTP(ctx context.Context, userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, secret, confirmed, last_used_step, created_at
+		FROM user_totp
+		WHERE user_id = $1`
+
+	var totp TOTP
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.Confirmed,
+		&totp.LastUsedStep,
+		&totp.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// UseTOTPStep records that the code for step has been accepted, confirming
+// the enrollment if it wasn't already. It returns ErrCodeReused if a code for
+// that step or a later one was accepted before, so each code works only once.
+func (m MFAModel) UseTOTPStep(ctx context.Context, userID, step int64) error {
+	query := `
+		UPDATE user_totp
+		SET last_used_step = $2, confirmed = true
+		WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrCodeReused
+	}
+
+	return nil
+}
+
+// DeleteTOTP turns two-factor authentication off for the user, removing the
+// enrollment and any recovery codes.
+func (m MFAModel) DeleteTOTP(ctx context.Context, userID int64) error {
+	query := `
+		WITH codes AS (
+			DELETE FROM recovery_codes WHERE user_id = $1
+		)
+		DELETE FROM user_totp WHERE user_id = $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, userID)
+	return err
+}
+
+// NewRecoveryCodes replaces the user's recovery codes with a fresh set and
+// returns them. Only their hashes are stored, so this is the one time the
+// plaintext codes are available.
+func (m MFAModel) NewRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+
+	for i := range codes {
+		randomBytes := make([]byte, 10)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+		codes[i] = code[:8] + "-" + code[8:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	query := `
+		WITH old AS (
+			DELETE FROM recovery_codes WHERE user_id = $1
+		)
+		INSERT INTO recovery_codes (user_id, hash)
+		SELECT $1, unnest($2::bytea[])`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode marks one of the user's unused recovery codes as used. It
+// returns ErrRecordNotFound if code doesn't match one.
+func (m MFAModel) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE id = (
+			SELECT id FROM recovery_codes
+			WHERE user_id = $1 AND hash = $2 AND used_at IS NULL
+			LIMIT 1
+			FOR UPDATE
+		)`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case, spaces and dashes
+// so that codes are accepted however the user types them.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
@@ -1,8 +1,12 @@
 package data
 
 import (
+	"context"
 	"errors"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -11,6 +15,16 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// DBTX is the subset of pgx shared by *pgxpool.Pool and pgx.Tx, which lets the
+// same models run either directly against the pool or inside a transaction.
+type DBTX interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
 type Models struct {
 	Movies              MovieModel
 	Permissions         PermissionsModel
@@ -19,16 +33,82 @@ type Models struct {
 	ResourcePermissions ResourcePermissionModel
 	Roles               RoleModel
 	TrustedClients      TrustedClientModel
+	TrustedClientKeys   TrustedClientKeyModel
+	Jobs                JobModel
+	Lockouts            LockoutModel
+	MFA                 MFAModel
+	RateLimits          RateLimitModel
+	Schema              SchemaModel
+
+	db           DBTX
+	queryTimeout time.Duration
+}
+
+// NewModels returns the models backed by pool. Each query is bounded by
+// queryTimeout in addition to the caller's context; zero disables the default
+// timeout.
+func NewModels(pool *pgxpool.Pool, queryTimeout time.Duration) Models {
+	return newModels(pool, queryTimeout)
 }
 
-func NewModels(pool *pgxpool.Pool) Models {
+func newModels(db DBTX, queryTimeout time.Duration) Models {
 	return Models{
-		Movies:              MovieModel{Pool: pool},
-		Permissions:         PermissionsModel{Pool: pool},
-		Tokens:              TokenModel{Pool: pool},
-		Users:               UserModel{Pool: pool},
-		ResourcePermissions: ResourcePermissionModel{Pool: pool},
-		Roles:               RoleModel{Pool: pool},
-		TrustedClients:      TrustedClientModel{Pool: pool},
+		Movies:              MovieModel{DB: db, QueryTimeout: queryTimeout},
+		Permissions:         PermissionsModel{DB: db, QueryTimeout: queryTimeout},
+		Tokens:              TokenModel{DB: db, QueryTimeout: queryTimeout},
+		Users:               UserModel{DB: db, QueryTimeout: queryTimeout},
+		ResourcePermissions: ResourcePermissionModel{DB: db, QueryTimeout: queryTimeout},
+		Roles:               RoleModel{DB: db, QueryTimeout: queryTimeout},
+		TrustedClients:      TrustedClientModel{DB: db, QueryTimeout: queryTimeout},
+		TrustedClientKeys:   TrustedClientKeyModel{DB: db, QueryTimeout: queryTimeout},
+		Jobs:                JobModel{DB: db, QueryTimeout: queryTimeout},
+		Lockouts:            LockoutModel{DB: db, QueryTimeout: queryTimeout},
+		MFA:                 MFAModel{DB: db, QueryTimeout: queryTimeout},
+		RateLimits:          RateLimitModel{DB: db, QueryTimeout: queryTimeout},
+		Schema:              SchemaModel{DB: db, QueryTimeout: queryTimeout},
+		db:                  db,
+		queryTimeout:        queryTimeout,
+	}
+}
+
+// Transaction runs fn with a copy of the models bound to a single database
+// transaction. The transaction is committed if fn returns nil and rolled back
+// otherwise. Calling Transaction on models that are already transactional
+// nests the work in a savepoint.
+func (m Models) Transaction(ctx context.Context, fn func(tx Models) error) error {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = fn(newModels(tx, m.queryTimeout))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// withQueryTimeout bounds ctx by a model's query timeout. A zero timeout
+// leaves the caller's deadline, if any, as the only limit.
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation on
+// the named constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
+// isForeignKeyViolation reports whether err is a foreign key violation on the
+// named constraint.
+func isForeignKeyViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503" && pgErr.ConstraintName == constraint
 }
@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/shadyar-bakr/greenlight/internal/validator"
 )
 
@@ -39,7 +38,8 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 }
 
 type MovieModel struct {
-	Pool *pgxpool.Pool
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
 func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
@@ -50,7 +50,10 @@ func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
 
 	args := []any{movie.Title, movie.Year, movie.Runtime, movie.Genres}
 
-	err := m.Pool.QueryRow(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 	if err != nil {
 		return err
 	}
@@ -70,7 +73,10 @@ func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 
 	var movie Movie
 
-	err := m.Pool.QueryRow(ctx, query, id).Scan(
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -108,7 +114,10 @@ func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
 		movie.Version,
 	}
 
-	err := m.Pool.QueryRow(ctx, query, args...).Scan(&movie.Version)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -130,7 +139,10 @@ func (m MovieModel) Delete(ctx context.Context, id int64) error {
 		DELETE FROM movies
 		WHERE id = $1`
 
-	result, err := m.Pool.Exec(ctx, query, id)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -160,7 +172,10 @@ func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, f
 		filters.Getoffset(),
 	}
 
-	rows, err := m.Pool.Query(ctx, query, args...)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -196,3 +211,80 @@ func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, f
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 	return movies, metadata, nil
 }
+
+// InsertMany bulk loads movies inside a single transaction using the COPY
+// protocol. Either every movie is inserted or none are. Large loads can take
+// a while, so the default query timeout is not applied.
+func (m MovieModel) InsertMany(ctx context.Context, movies []*Movie) (int64, error) {
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows := make([][]any, len(movies))
+	for i, movie := range movies {
+		rows[i] = []any{movie.Title, movie.Year, movie.Runtime, movie.Genres}
+	}
+
+	count, err := tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"movies"},
+		[]string{"title", "year", "runtime", "genres"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Stream calls fn for every movie matching the filters, in sort order, without
+// buffering the result set. Pagination fields in filters are ignored. Like
+// InsertMany it is bounded only by ctx, since exports run as long as the
+// client keeps reading.
+func (m MovieModel) Stream(ctx context.Context, title string, genres []string, filters Filters, fn func(*Movie) error) error {
+	query := fmt.Sprintf(`
+		SELECT id, created_at, title, year, runtime, genres, version
+			FROM movies
+			WHERE (LOWER(title) LIKE LOWER($1) OR $1 = '')
+			AND (genres @> $2 OR $2 = '{}')
+			ORDER BY %s %s, id ASC`,
+		filters.GetSortColumn(), filters.GetSortDirection())
+
+	rows, err := m.DB.Query(ctx, query, "%"+title+"%", genres)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			&movie.Genres,
+			&movie.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
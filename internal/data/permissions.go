@@ -4,32 +4,49 @@ import (
 	"context"
 	"slices"
 	"time"
-
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Permissions []string
 
 type PermissionsModel struct {
-	Pool *pgxpool.Pool
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
 func (p Permissions) Include(code string) bool {
 	return slices.Contains(p, code)
 }
 
-func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
+// GetAllForUser returns the permissions granted to a user directly and through
+// their roles, including roles inherited from parent roles.
+func (m PermissionsModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
+		WITH RECURSIVE user_roles AS (
+			SELECT roles.id, roles.parent_id
+			FROM roles
+			INNER JOIN users_roles ON roles.id = users_roles.role_id
+			WHERE users_roles.user_id = $1
+
+			UNION
+
+			SELECT roles.id, roles.parent_id
+			FROM roles
+			INNER JOIN user_roles ON roles.id = user_roles.parent_id
+		)
 		SELECT permissions.code
 		FROM permissions
 		INNER JOIN users_permissions ON permissions.id = users_permissions.permission_id
-		INNER JOIN users ON users.id = users_permissions.user_id
-		WHERE users.id = $1
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON permissions.id = roles_permissions.permission_id
+		INNER JOIN user_roles ON user_roles.id = roles_permissions.role_id
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	rows, err := m.Pool.Query(ctx, query, userID)
+	rows, err := m.DB.Query(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -53,16 +70,145 @@ func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-func (m PermissionsModel) AddForUser(userID int64, codes ...string) error {
+func (m PermissionsModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions (user_id, permission_id)
 		SELECT $1, permissions.id
 		FROM permissions
 		WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING
+	`
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, userID, codes)
+	return err
+}
+
+func (m PermissionsModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+		AND users_permissions.user_id = $1
+		AND permissions.code = ANY($2)
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	_, err := m.Pool.Exec(ctx, query, userID, codes)
+	_, err := m.DB.Exec(ctx, query, userID, codes)
 	return err
 }
+
+// GetAll returns every permission code known to the system.
+func (m PermissionsModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code
+	`
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// GetAllForClient returns the permissions a trusted client may be granted as
+// OAuth scopes.
+func (m PermissionsModel) GetAllForClient(ctx context.Context, clientID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN trusted_clients_permissions ON permissions.id = trusted_clients_permissions.permission_id
+		WHERE trusted_clients_permissions.client_id = $1
+		ORDER BY permissions.code
+	`
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// SetForClient replaces the permissions a trusted client may be granted, and
+// takes any it loses away from the tokens it already holds. Unknown codes are
+// ignored, so callers should validate them first.
+func (m PermissionsModel) SetForClient(ctx context.Context, clientID int64, codes ...string) error {
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM trusted_clients_permissions WHERE client_id = $1`, clientID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO trusted_clients_permissions (client_id, permission_id)
+		SELECT $1, permissions.id
+		FROM permissions
+		WHERE permissions.code = ANY($2)
+	`
+	_, err = tx.Exec(ctx, query, clientID, codes)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE tokens
+		SET scopes = ARRAY(SELECT code FROM unnest(scopes) AS code WHERE code = ANY($2))
+		WHERE client_id = $1
+	`
+	_, err = tx.Exec(ctx, query, clientID, codes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+type RateLimitModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// Take records a request for key using the generic cell rate algorithm:
+// requests are spaced emission apart on average, and may arrive up to
+// tolerance ahead of that schedule. It reports whether the request is allowed
+// and how far the key's theoretical arrival time is ahead of now, from which
+// the remaining burst and retry delay follow. The check and update are a
+// single statement, so concurrent API instances share one allowance.
+func (m RateLimitModel) Take(ctx context.Context, key string, emission, tolerance time.Duration) (bool, time.Duration, error) {
+	query := `
+		INSERT INTO rate_limits (key, tat, allowed)
+		VALUES ($1, now() + $2::bigint * interval '1 microsecond', $2::bigint <= $3::bigint)
+		ON CONFLICT (key) DO UPDATE SET
+			allowed = greatest(rate_limits.tat, now()) + $2::bigint * interval '1 microsecond' <= now() + $3::bigint * interval '1 microsecond',
+			tat = CASE
+				WHEN greatest(rate_limits.tat, now()) + $2::bigint * interval '1 microsecond' <= now() + $3::bigint * interval '1 microsecond'
+				THEN greatest(rate_limits.tat, now()) + $2::bigint * interval '1 microsecond'
+				ELSE rate_limits.tat
+			END
+		RETURNING allowed, (extract(epoch FROM tat - now()) * 1000000)::bigint`
+
+	var (
+		allowed bool
+		ahead   int64
+	)
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, key, emission.Microseconds(), tolerance.Microseconds()).Scan(&allowed, &ahead)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return allowed, time.Duration(ahead) * time.Microsecond, nil
+}
+
+// DeleteExpired removes keys whose full burst is available again, which is
+// the same as having no row. It returns the number of rows deleted.
+func (m RateLimitModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM rate_limits WHERE tat < now()`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected(), nil
+}
@@ -3,8 +3,6 @@ package data
 import (
 	"context"
 	"time"
-
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // ResourcePermission represents a permission for a specific resource
@@ -20,11 +18,12 @@ type ResourcePermission struct {
 
 // ResourcePermissionModel wraps a database connection pool
 type ResourcePermissionModel struct {
-	Pool *pgxpool.Pool
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
 // Grant adds a new resource-level permission for a user
-func (m ResourcePermissionModel) Grant(rp *ResourcePermission) error {
+func (m ResourcePermissionModel) Grant(ctx context.Context, rp *ResourcePermission) error {
 	query := `
 		INSERT INTO resource_permissions (user_id, resource_type, resource_id, permission, granted_by)
 		VALUES ($1, $2, $3, $4, $5)
@@ -38,22 +37,22 @@ func (m ResourcePermissionModel) Grant(rp *ResourcePermission) error {
 		rp.GrantedBy,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	return m.Pool.QueryRow(ctx, query, args...).Scan(&rp.ID, &rp.CreatedAt)
+	return m.DB.QueryRow(ctx, query, args...).Scan(&rp.ID, &rp.CreatedAt)
 }
 
 // Revoke removes a resource-level permission from a user
-func (m ResourcePermissionModel) Revoke(userID int64, resourceType string, resourceID int64, permission string) error {
+func (m ResourcePermissionModel) Revoke(ctx context.Context, userID int64, resourceType string, resourceID int64, permission string) error {
 	query := `
 		DELETE FROM resource_permissions
 		WHERE user_id = $1 AND resource_type = $2 AND resource_id = $3 AND permission = $4`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	result, err := m.Pool.Exec(ctx, query, userID, resourceType, resourceID, permission)
+	result, err := m.DB.Exec(ctx, query, userID, resourceType, resourceID, permission)
 	if err != nil {
 		return err
 	}
@@ -66,18 +65,18 @@ func (m ResourcePermissionModel) Revoke(userID int64, resourceType string, resou
 }
 
 // HasPermission checks if a user has a specific permission for a resource
-func (m ResourcePermissionModel) HasPermission(userID int64, resourceType string, resourceID int64, permission string) (bool, error) {
+func (m ResourcePermissionModel) HasPermission(ctx context.Context, userID int64, resourceType string, resourceID int64, permission string) (bool, error) {
 	query := `
 		SELECT EXISTS(
 			SELECT 1 FROM resource_permissions
 			WHERE user_id = $1 AND resource_type = $2 AND resource_id = $3 AND permission = $4
 		)`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	var exists bool
-	err := m.Pool.QueryRow(ctx, query, userID, resourceType, resourceID, permission).Scan(&exists)
+	err := m.DB.QueryRow(ctx, query, userID, resourceType, resourceID, permission).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
@@ -86,16 +85,16 @@ func (m ResourcePermissionModel) HasPermission(userID int64, resourceType string
 }
 
 // GetResourcePermissions gets all permissions for a specific resource
-func (m ResourcePermissionModel) GetResourcePermissions(resourceType string, resourceID int64) ([]*ResourcePermission, error) {
+func (m ResourcePermissionModel) GetResourcePermissions(ctx context.Context, resourceType string, resourceID int64) ([]*ResourcePermission, error) {
 	query := `
 		SELECT id, user_id, resource_type, resource_id, permission, granted_by, created_at
 		FROM resource_permissions
 		WHERE resource_type = $1 AND resource_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	rows, err := m.Pool.Query(ctx, query, resourceType, resourceID)
+	rows, err := m.DB.Query(ctx, query, resourceType, resourceID)
 	if err != nil {
 		return nil, err
 	}
@@ -130,16 +129,16 @@ func (m ResourcePermissionModel) GetResourcePermissions(resourceType string, res
 }
 
 // GetUserResourcePermissions gets all resource permissions for a user
-func (m ResourcePermissionModel) GetUserResourcePermissions(userID int64, resourceType string) ([]*ResourcePermission, error) {
+func (m ResourcePermissionModel) GetUserResourcePermissions(ctx context.Context, userID int64, resourceType string) ([]*ResourcePermission, error) {
 	query := `
 		SELECT id, user_id, resource_type, resource_id, permission, granted_by, created_at
 		FROM resource_permissions
 		WHERE user_id = $1 AND resource_type = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	rows, err := m.Pool.Query(ctx, query, userID, resourceType)
+	rows, err := m.DB.Query(ctx, query, userID, resourceType)
 	if err != nil {
 		return nil, err
 	}
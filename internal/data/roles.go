@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Role struct {
@@ -19,11 +18,12 @@ type Role struct {
 }
 
 type RoleModel struct {
-	Pool *pgxpool.Pool
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
 // Insert adds a new role to the database
-func (m RoleModel) Insert(role *Role) error {
+func (m RoleModel) Insert(ctx context.Context, role *Role) error {
 	query := `
 		INSERT INTO roles (name, description, parent_id)
 		VALUES ($1, $2, $3)
@@ -31,14 +31,14 @@ func (m RoleModel) Insert(role *Role) error {
 
 	args := []any{role.Name, role.Description, role.ParentID}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	return m.Pool.QueryRow(ctx, query, args...).Scan(&role.ID, &role.CreatedAt, &role.Version)
+	return m.DB.QueryRow(ctx, query, args...).Scan(&role.ID, &role.CreatedAt, &role.Version)
 }
 
 // Get retrieves a specific role from the database
-func (m RoleModel) Get(id int64) (*Role, error) {
+func (m RoleModel) Get(ctx context.Context, id int64) (*Role, error) {
 	query := `
 		SELECT id, name, description, parent_id, created_at, version
 		FROM roles
@@ -46,10 +46,43 @@ func (m RoleModel) Get(id int64) (*Role, error) {
 
 	var role Role
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	err := m.Pool.QueryRow(ctx, query, id).Scan(
+	err := m.DB.QueryRow(ctx, query, id).Scan(
+		&role.ID,
+		&role.Name,
+		&role.Description,
+		&role.ParentID,
+		&role.CreatedAt,
+		&role.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &role, nil
+}
+
+// GetByName retrieves a role by its unique name
+func (m RoleModel) GetByName(ctx context.Context, name string) (*Role, error) {
+	query := `
+		SELECT id, name, description, parent_id, created_at, version
+		FROM roles
+		WHERE name = $1`
+
+	var role Role
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, name).Scan(
 		&role.ID,
 		&role.Name,
 		&role.Description,
@@ -71,7 +104,7 @@ func (m RoleModel) Get(id int64) (*Role, error) {
 }
 
 // Update updates a specific role in the database
-func (m RoleModel) Update(role *Role) error {
+func (m RoleModel) Update(ctx context.Context, role *Role) error {
 	query := `
 		UPDATE roles
 		SET name = $1, description = $2, parent_id = $3, version = version + 1
@@ -86,10 +119,10 @@ func (m RoleModel) Update(role *Role) error {
 		role.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	err := m.Pool.QueryRow(ctx, query, args...).Scan(&role.Version)
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&role.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -103,15 +136,15 @@ func (m RoleModel) Update(role *Role) error {
 }
 
 // Delete removes a role from the database
-func (m RoleModel) Delete(id int64) error {
+func (m RoleModel) Delete(ctx context.Context, id int64) error {
 	query := `
 		DELETE FROM roles
 		WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	result, err := m.Pool.Exec(ctx, query, id)
+	result, err := m.DB.Exec(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -124,16 +157,16 @@ func (m RoleModel) Delete(id int64) error {
 }
 
 // GetAll retrieves all roles from the database
-func (m RoleModel) GetAll() ([]*Role, error) {
+func (m RoleModel) GetAll(ctx context.Context) ([]*Role, error) {
 	query := `
 		SELECT id, name, description, parent_id, created_at, version
 		FROM roles
 		ORDER BY id`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	rows, err := m.Pool.Query(ctx, query)
+	rows, err := m.DB.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -167,7 +200,7 @@ func (m RoleModel) GetAll() ([]*Role, error) {
 }
 
 // GetAllForUser retrieves all roles assigned to a specific user
-func (m RoleModel) GetAllForUser(userID int64) ([]*Role, error) {
+func (m RoleModel) GetAllForUser(ctx context.Context, userID int64) ([]*Role, error) {
 	query := `
 		SELECT r.id, r.name, r.description, r.parent_id, r.created_at, r.version
 		FROM roles r
@@ -175,10 +208,10 @@ func (m RoleModel) GetAllForUser(userID int64) ([]*Role, error) {
 		WHERE ur.user_id = $1
 		ORDER BY r.id`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	rows, err := m.Pool.Query(ctx, query, userID)
+	rows, err := m.DB.Query(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -211,29 +244,32 @@ func (m RoleModel) GetAllForUser(userID int64) ([]*Role, error) {
 	return roles, nil
 }
 
-// AssignToUser assigns a role to a user
-func (m RoleModel) AssignToUser(userID, roleID, grantedBy int64) error {
+// AssignToUser assigns a role to a user. A grantedBy of 0 records the role as
+// granted by nobody, as when bootstrapping from the admin CLI. Assigning a
+// role the user already has is a no-op.
+func (m RoleModel) AssignToUser(ctx context.Context, userID, roleID, grantedBy int64) error {
 	query := `
 		INSERT INTO users_roles (user_id, role_id, granted_by)
-		VALUES ($1, $2, $3)`
+		VALUES ($1, $2, NULLIF($3, 0))
+		ON CONFLICT DO NOTHING`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	_, err := m.Pool.Exec(ctx, query, userID, roleID, grantedBy)
+	_, err := m.DB.Exec(ctx, query, userID, roleID, grantedBy)
 	return err
 }
 
 // UnassignFromUser removes a role from a user
-func (m RoleModel) UnassignFromUser(userID, roleID int64) error {
+func (m RoleModel) UnassignFromUser(ctx context.Context, userID, roleID int64) error {
 	query := `
 		DELETE FROM users_roles
 		WHERE user_id = $1 AND role_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	result, err := m.Pool.Exec(ctx, query, userID, roleID)
+	result, err := m.DB.Exec(ctx, query, userID, roleID)
 	if err != nil {
 		return err
 	}
@@ -246,7 +282,7 @@ func (m RoleModel) UnassignFromUser(userID, roleID int64) error {
 }
 
 // GetAllPermissions retrieves all permissions for a role, including inherited ones
-func (m RoleModel) GetAllPermissions(roleID int64) (Permissions, error) {
+func (m RoleModel) GetAllPermissions(ctx context.Context, roleID int64) (Permissions, error) {
 	query := `
 		WITH RECURSIVE role_hierarchy AS (
 			-- Base case: start with the given role
@@ -267,10 +303,10 @@ func (m RoleModel) GetAllPermissions(roleID int64) (Permissions, error) {
 		INNER JOIN role_hierarchy rh ON rp.role_id = rh.id
 		ORDER BY p.code`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	rows, err := m.Pool.Query(ctx, query, roleID)
+	rows, err := m.DB.Query(ctx, query, roleID)
 	if err != nil {
 		return nil, err
 	}
@@ -297,28 +333,28 @@ func (m RoleModel) GetAllPermissions(roleID int64) (Permissions, error) {
 }
 
 // AssignPermission assigns a permission to a role
-func (m RoleModel) AssignPermission(roleID, permissionID int64) error {
+func (m RoleModel) AssignPermission(ctx context.Context, roleID, permissionID int64) error {
 	query := `
 		INSERT INTO roles_permissions (role_id, permission_id)
 		VALUES ($1, $2)`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	_, err := m.Pool.Exec(ctx, query, roleID, permissionID)
+	_, err := m.DB.Exec(ctx, query, roleID, permissionID)
 	return err
 }
 
 // UnassignPermission removes a permission from a role
-func (m RoleModel) UnassignPermission(roleID, permissionID int64) error {
+func (m RoleModel) UnassignPermission(ctx context.Context, roleID, permissionID int64) error {
 	query := `
 		DELETE FROM roles_permissions
 		WHERE role_id = $1 AND permission_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	result, err := m.Pool.Exec(ctx, query, roleID, permissionID)
+	result, err := m.DB.Exec(ctx, query, roleID, permissionID)
 	if err != nil {
 		return err
 	}
@@ -36,3 +36,16 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 	*r = Runtime(i)
 	return nil
 }
+
+// ParseRuntime parses a runtime written either as "<n> mins" or as a bare
+// number of minutes, the form used by CSV imports and exports.
+func ParseRuntime(s string) (Runtime, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), " mins")
+
+	i, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	return Runtime(i), nil
+}
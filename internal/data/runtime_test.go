@@ -0,0 +1,39 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRuntime(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Runtime
+		wantErr error
+	}{
+		{name: "bare minutes", input: "102", want: 102},
+		{name: "with unit", input: "102 mins", want: 102},
+		{name: "surrounding space", input: "  95 mins ", want: 95},
+		{name: "zero", input: "0", want: 0},
+		{name: "negative", input: "-5", want: -5},
+		{name: "empty", input: "", wantErr: ErrInvalidRuntimeFormat},
+		{name: "unit only", input: "mins", wantErr: ErrInvalidRuntimeFormat},
+		{name: "wrong unit", input: "102 hours", wantErr: ErrInvalidRuntimeFormat},
+		{name: "unit without space", input: "102mins", wantErr: ErrInvalidRuntimeFormat},
+		{name: "decimal", input: "1.5", wantErr: ErrInvalidRuntimeFormat},
+		{name: "overflows int32", input: "2147483648", wantErr: ErrInvalidRuntimeFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRuntime(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseRuntime(%q) error = %v; want %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRuntime(%q) = %d; want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
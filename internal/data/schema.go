@@ -0,0 +1,29 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+type SchemaModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// Version returns the migration version recorded by tern in the
+// schema_version table.
+func (m SchemaModel) Version(ctx context.Context) (int, error) {
+	query := `SELECT version FROM schema_version`
+
+	var version int
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
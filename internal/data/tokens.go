@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/shadyar-bakr/greenlight/internal/validator"
 )
 
@@ -22,6 +21,13 @@ const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
 	ScopeRefresh        = "refresh"
+	ScopeUnlock         = "unlock"
+	ScopeMFA            = "mfa"
+
+	// Tokens issued to trusted clients through OAuth. They are kept apart from
+	// the user scopes so that a client token can never pass for a user's.
+	ScopeClientAccess  = "client_access"
+	ScopeClientRefresh = "client_refresh"
 )
 
 type Token struct {
@@ -31,6 +37,11 @@ type Token struct {
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
 	IsRefresh bool      `json:"-"`
+
+	// ClientID and Scopes are set on tokens issued to trusted clients, which
+	// are limited to the permission codes in Scopes.
+	ClientID int64       `json:"-"`
+	Scopes   Permissions `json:"-"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -59,48 +70,68 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	Pool *pgxpool.Pool
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
-func (m *TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m *TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
-func (m *TokenModel) Insert(token *Token) error {
+func (m *TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope, is_refresh)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO tokens (hash, user_id, client_id, expiry, scope, is_refresh, scopes)
+		VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), $4, $5, $6, COALESCE($7, '{}'::text[]))
 	`
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.IsRefresh}
+	args := []any{token.Hash, token.UserID, token.ClientID, token.Expiry, token.Scope, token.IsRefresh, []string(token.Scopes)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	_, err := m.Pool.Exec(ctx, query, args...)
+	_, err := m.DB.Exec(ctx, query, args...)
 	return err
 }
 
-func (m *TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m *TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens
 		WHERE scope = $1 AND user_id = $2
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	_, err := m.Pool.Exec(ctx, query, scope, userID)
+	_, err := m.DB.Exec(ctx, query, scope, userID)
 	return err
 }
 
+// DeleteExpired removes every token past its expiry and returns how many were
+// deleted.
+func (m *TokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expiry < NOW()
+	`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected(), nil
+}
+
 // NewPair creates both an access token and refresh token for a user
-func (m *TokenModel) NewPair(userID int64, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
+func (m *TokenModel) NewPair(ctx context.Context, userID int64, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
 	accessToken, err := generateToken(userID, accessTTL, ScopeAuthentication)
 	if err != nil {
 		return nil, nil, err
@@ -112,12 +143,12 @@ func (m *TokenModel) NewPair(userID int64, accessTTL, refreshTTL time.Duration)
 	}
 	refreshToken.IsRefresh = true
 
-	err = m.Insert(accessToken)
+	err = m.Insert(ctx, accessToken)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	err = m.Insert(refreshToken)
+	err = m.Insert(ctx, refreshToken)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -126,7 +157,7 @@ func (m *TokenModel) NewPair(userID int64, accessTTL, refreshTTL time.Duration)
 }
 
 // GetRefreshToken retrieves a refresh token from the database
-func (m *TokenModel) GetRefreshToken(tokenPlaintext string) (*Token, error) {
+func (m *TokenModel) GetRefreshToken(ctx context.Context, tokenPlaintext string) (*Token, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
@@ -136,10 +167,10 @@ func (m *TokenModel) GetRefreshToken(tokenPlaintext string) (*Token, error) {
 	`
 
 	var token Token
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	err := m.Pool.QueryRow(ctx, query, tokenHash[:], ScopeRefresh).Scan(
+	err := m.DB.QueryRow(ctx, query, tokenHash[:], ScopeRefresh).Scan(
 		&token.UserID,
 		&token.Expiry,
 		&token.Scope,
@@ -160,3 +191,98 @@ func (m *TokenModel) GetRefreshToken(tokenPlaintext string) (*Token, error) {
 
 	return &token, nil
 }
+
+// NewClientPair creates an access token and refresh token for a trusted
+// client, limited to the given scopes.
+func (m *TokenModel) NewClientPair(ctx context.Context, clientID int64, scopes Permissions, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
+	accessToken, err := generateToken(0, accessTTL, ScopeClientAccess)
+	if err != nil {
+		return nil, nil, err
+	}
+	accessToken.ClientID = clientID
+	accessToken.Scopes = scopes
+
+	refreshToken, err := generateToken(0, refreshTTL, ScopeClientRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+	refreshToken.ClientID = clientID
+	refreshToken.Scopes = scopes
+	refreshToken.IsRefresh = true
+
+	err = m.Insert(ctx, accessToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = m.Insert(ctx, refreshToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// GetForClient returns an unexpired token issued to a trusted client that is
+// still enabled, whatever its scope. It returns ErrRecordNotFound for any
+// other token.
+func (m *TokenModel) GetForClient(ctx context.Context, tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT tokens.client_id, tokens.expiry, tokens.scope, tokens.is_refresh, tokens.scopes
+		FROM tokens
+		INNER JOIN trusted_clients ON trusted_clients.id = tokens.client_id
+		WHERE tokens.hash = $1 AND tokens.expiry > NOW() AND trusted_clients.enabled
+	`
+
+	token := Token{Plaintext: tokenPlaintext, Hash: tokenHash[:]}
+	var scopes []string
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, tokenHash[:]).Scan(
+		&token.ClientID,
+		&token.Expiry,
+		&token.Scope,
+		&token.IsRefresh,
+		&scopes,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	token.Scopes = scopes
+
+	return &token, nil
+}
+
+// DeleteForClient deletes a token if it was issued to the given client. It
+// returns ErrRecordNotFound if there was no such token.
+func (m *TokenModel) DeleteForClient(ctx context.Context, clientID int64, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND client_id = $2
+	`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, tokenHash[:], clientID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
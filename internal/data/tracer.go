@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shadyar-bakr/greenlight/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type queryStartKey struct{}
+
+// QueryTracer is a pgx tracer that wraps every query and COPY in an
+// OpenTelemetry span, parented to whatever span is in the query's context.
+// Queries are also logged at debug level through the logger in the context,
+// so they carry the request or job attributes of the caller.
+type QueryTracer struct {
+	tracer trace.Tracer
+}
+
+func NewQueryTracer() *QueryTracer {
+	return &QueryTracer{
+		tracer: otel.Tracer("github.com/shadyar-bakr/greenlight/internal/data"),
+	}
+}
+
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = t.tracer.Start(ctx, "db "+sqlOperation(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", strings.TrimSpace(data.SQL)),
+		),
+	)
+	return context.WithValue(ctx, queryStartKey{}, queryStart{sql: data.SQL, time: time.Now()})
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	recordSpanError(span, data.Err)
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	span.End()
+
+	logger := logging.FromContext(ctx)
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	if start, ok := ctx.Value(queryStartKey{}).(queryStart); ok {
+		attrs := []slog.Attr{
+			slog.String("sql", strings.Join(strings.Fields(start.sql), " ")),
+			slog.Float64("duration_ms", float64(time.Since(start.time))/float64(time.Millisecond)),
+			slog.Int64("rows", data.CommandTag.RowsAffected()),
+		}
+		if data.Err != nil {
+			attrs = append(attrs, slog.String("error", data.Err.Error()))
+		}
+		logger.LogAttrs(ctx, slog.LevelDebug, "query", attrs...)
+	}
+}
+
+type queryStart struct {
+	sql  string
+	time time.Time
+}
+
+func (t *QueryTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	ctx, _ = t.tracer.Start(ctx, "db COPY "+strings.Join(data.TableName, "."),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.StringSlice("db.copy.columns", data.ColumnNames),
+		),
+	)
+	return ctx
+}
+
+func (t *QueryTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	span := trace.SpanFromContext(ctx)
+	recordSpanError(span, data.Err)
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	span.End()
+}
+
+func recordSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// sqlOperation returns the leading keyword of a statement (SELECT, INSERT, ...)
+// for use as a low-cardinality span name.
+func sqlOperation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "QUERY"
+	}
+	return strings.ToUpper(fields[0])
+}
@@ -0,0 +1,190 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"time"
+)
+
+// apiKeyPrefixLength is how much of an API key is kept in plaintext to tell
+// keys apart. It is far too little to help guess the rest.
+const apiKeyPrefixLength = 8
+
+// TrustedClientKey is one of a trusted client's API keys.
+type TrustedClientKey struct {
+	ID         int64      `json:"id"`
+	ClientID   int64      `json:"client_id"`
+	Prefix     string     `json:"prefix,omitempty"`
+	APIKey     string     `json:"api_key,omitempty"` // Only populated when creating a new key
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// generateAPIKey returns a new random API key along with its hash for storage.
+func generateAPIKey() (string, []byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Convert to base32 for easier handling
+	apiKey := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+
+	// Hash the API key for storage
+	hash := sha256.Sum256([]byte(apiKey))
+
+	return apiKey, hash[:], nil
+}
+
+type TrustedClientKeyModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// New creates a new API key for a trusted client, optionally expiring at
+// expiresAt. The client's other keys keep working for the grace period and
+// then expire, giving consumers time to switch over; a zero grace period
+// expires them immediately. Keys that have already expired are removed. It
+// returns ErrRecordNotFound if the client doesn't exist.
+func (m TrustedClientKeyModel) New(ctx context.Context, clientID int64, expiresAt *time.Time, grace time.Duration) (*TrustedClientKey, error) {
+	apiKey, hash, err := generateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &TrustedClientKey{
+		ClientID:  clientID,
+		Prefix:    apiKey[:apiKeyPrefixLength],
+		APIKey:    apiKey,
+		ExpiresAt: expiresAt,
+	}
+
+	query := `
+		WITH expired AS (
+			DELETE FROM trusted_client_keys
+			WHERE client_id = $1 AND expires_at <= NOW()
+		), expiring AS (
+			UPDATE trusted_client_keys
+			SET expires_at = NOW() + $5 * interval '1 microsecond'
+			WHERE client_id = $1 AND (expires_at IS NULL OR expires_at > NOW() + $5 * interval '1 microsecond')
+		)
+		INSERT INTO trusted_client_keys (client_id, prefix, hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	args := []any{clientID, key.Prefix, hash, expiresAt, grace.Microseconds()}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRow(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err, "trusted_client_keys_client_id_fkey"):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// GetAllForClient returns a trusted client's keys that haven't expired,
+// newest first.
+func (m TrustedClientKeyModel) GetAllForClient(ctx context.Context, clientID int64) ([]*TrustedClientKey, error) {
+	query := `
+		SELECT id, client_id, prefix, created_at, last_used_at, expires_at
+		FROM trusted_client_keys
+		WHERE client_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*TrustedClientKey{}
+
+	for rows.Next() {
+		var key TrustedClientKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.ClientID,
+			&key.Prefix,
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Revoke deletes one of a trusted client's keys, which stops working
+// immediately. It returns ErrRecordNotFound if the client has no such key.
+func (m TrustedClientKeyModel) Revoke(ctx context.Context, clientID, keyID int64) error {
+	query := `
+		DELETE FROM trusted_client_keys
+		WHERE id = $1 AND client_id = $2`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, keyID, clientID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RecordUsage sets when keys were last used, given as a map from key ID to
+// time. It never moves a key's last use back in time, so batches written out
+// of order are harmless.
+func (m TrustedClientKeyModel) RecordUsage(ctx context.Context, used map[int64]time.Time) error {
+	if len(used) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(used))
+	times := make([]time.Time, 0, len(used))
+	for id, t := range used {
+		ids = append(ids, id)
+		times = append(times, t)
+	}
+
+	query := `
+		UPDATE trusted_client_keys
+		SET last_used_at = GREATEST(trusted_client_keys.last_used_at, used.at)
+		FROM unnest($1::bigint[], $2::timestamptz[]) AS used(id, at)
+		WHERE trusted_client_keys.id = used.id`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, ids, times)
+	return err
+}
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/shadyar-bakr/greenlight/internal/validator"
+)
+
+// UsageIntervals are the bucket sizes usage can be reported in.
+var UsageIntervals = []string{"hour", "day"}
+
+// UsageStats are request counts for a trusted client over some period.
+type UsageStats struct {
+	Requests      int64   `json:"requests"`
+	ClientErrors  int64   `json:"client_errors"`
+	ServerErrors  int64   `json:"server_errors"`
+	ErrorRate     float64 `json:"error_rate"`
+	AvgDurationMS float64 `json:"avg_duration_ms"`
+}
+
+func newUsageStats(requests, clientErrors, serverErrors int64, totalDurationMS float64) UsageStats {
+	stats := UsageStats{
+		Requests:     requests,
+		ClientErrors: clientErrors,
+		ServerErrors: serverErrors,
+	}
+
+	if requests > 0 {
+		stats.ErrorRate = float64(clientErrors+serverErrors) / float64(requests)
+		stats.AvgDurationMS = totalDurationMS / float64(requests)
+	}
+
+	return stats
+}
+
+// UsageBucket is a trusted client's usage in the interval starting at Start.
+type UsageBucket struct {
+	Start time.Time `json:"start"`
+	UsageStats
+}
+
+// EndpointUsage is a trusted client's usage of one endpoint, identified by
+// its route pattern.
+type EndpointUsage struct {
+	Method   string `json:"method"`
+	Endpoint string `json:"endpoint"`
+	UsageStats
+}
+
+// Usage is a trusted client's usage over a period.
+type Usage struct {
+	Buckets      []*UsageBucket   `json:"buckets"`
+	TopEndpoints []*EndpointUsage `json:"top_endpoints"`
+}
+
+func ValidateUsageQuery(v *validator.Validator, from, to time.Time, interval string, top int) {
+	v.Check(validator.PermittedValue(interval, UsageIntervals...), "interval", "must be hour or day")
+	v.Check(from.Before(to), "from", "must be before to")
+	v.Check(to.Sub(from) <= 366*24*time.Hour, "from", "must not be more than 366 days before to")
+	v.Check(interval != "hour" || to.Sub(from) <= 31*24*time.Hour, "interval", "must be day for periods longer than 31 days")
+	v.Check(top >= 1 && top <= 100, "top", "must be between 1 and 100")
+}
+
+// usageRequests selects a client's requests between $2 and $3, both from the
+// request logs and the daily rollups of older logs. Days that have been
+// rolled up are counted whole if they overlap the period at all.
+const usageRequests = `
+	WITH requests AS (
+		SELECT timestamp AS at, endpoint, method,
+			1::bigint AS requests,
+			(status_code BETWEEN 400 AND 499)::int::bigint AS client_errors,
+			(status_code >= 500)::int::bigint AS server_errors,
+			duration_ms
+		FROM trusted_client_logs
+		WHERE client_id = $1 AND timestamp >= $2 AND timestamp < $3
+
+		UNION ALL
+
+		SELECT day::timestamp AT TIME ZONE 'UTC', endpoint, method,
+			requests, client_errors, server_errors, total_duration_ms
+		FROM trusted_client_usage_daily
+		WHERE client_id = $1
+		AND day::timestamp AT TIME ZONE 'UTC' < $3
+		AND (day + 1)::timestamp AT TIME ZONE 'UTC' > $2
+	)`
+
+// Usage returns a trusted client's usage between from and to, in buckets of
+// the given interval aligned to UTC, along with the top most used endpoints.
+func (m TrustedClientModel) Usage(ctx context.Context, clientID int64, from, to time.Time, interval string, top int) (*Usage, error) {
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	query := usageRequests + `
+		SELECT date_trunc($4, at, 'UTC') AS bucket, sum(requests)::bigint, sum(client_errors)::bigint,
+			sum(server_errors)::bigint, sum(duration_ms)
+		FROM requests
+		GROUP BY bucket
+		ORDER BY bucket`
+
+	rows, err := m.DB.Query(ctx, query, clientID, from, to, interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := &Usage{
+		Buckets:      []*UsageBucket{},
+		TopEndpoints: []*EndpointUsage{},
+	}
+
+	for rows.Next() {
+		var (
+			bucket                               UsageBucket
+			requests, clientErrors, serverErrors int64
+			totalDuration                        float64
+		)
+
+		err := rows.Scan(&bucket.Start, &requests, &clientErrors, &serverErrors, &totalDuration)
+		if err != nil {
+			return nil, err
+		}
+
+		bucket.UsageStats = newUsageStats(requests, clientErrors, serverErrors, totalDuration)
+		usage.Buckets = append(usage.Buckets, &bucket)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = usageRequests + `
+		SELECT method, endpoint, sum(requests)::bigint AS total, sum(client_errors)::bigint,
+			sum(server_errors)::bigint, sum(duration_ms)
+		FROM requests
+		GROUP BY method, endpoint
+		ORDER BY total DESC, endpoint, method
+		LIMIT $4`
+
+	rows, err = m.DB.Query(ctx, query, clientID, from, to, top)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			endpoint                             EndpointUsage
+			requests, clientErrors, serverErrors int64
+			totalDuration                        float64
+		)
+
+		err := rows.Scan(&endpoint.Method, &endpoint.Endpoint, &requests, &clientErrors, &serverErrors, &totalDuration)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoint.UsageStats = newUsageStats(requests, clientErrors, serverErrors, totalDuration)
+		usage.TopEndpoints = append(usage.TopEndpoints, &endpoint)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// RollupLogs moves up to limit of the request logs from before t into the
+// daily rollups and returns how many were moved. Call it until it moves fewer
+// than limit to roll up everything; each call is a short statement of its own,
+// so a large backlog doesn't hold locks or run into the query timeout.
+func (m TrustedClientModel) RollupLogs(ctx context.Context, t time.Time, limit int) (int64, error) {
+	query := `
+		WITH old AS (
+			DELETE FROM trusted_client_logs
+			WHERE id IN (
+				SELECT id FROM trusted_client_logs
+				WHERE timestamp < $1
+				ORDER BY timestamp
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING client_id, timestamp, endpoint, method, status_code, duration_ms
+		), rolled AS (
+			INSERT INTO trusted_client_usage_daily AS usage
+				(client_id, day, endpoint, method, requests, client_errors, server_errors, total_duration_ms)
+			SELECT client_id, (timestamp AT TIME ZONE 'UTC')::date, endpoint, method, count(*),
+				count(*) FILTER (WHERE status_code BETWEEN 400 AND 499),
+				count(*) FILTER (WHERE status_code >= 500),
+				sum(duration_ms)
+			FROM old
+			GROUP BY 1, 2, 3, 4
+			ON CONFLICT (client_id, day, endpoint, method) DO UPDATE
+			SET requests = usage.requests + EXCLUDED.requests,
+				client_errors = usage.client_errors + EXCLUDED.client_errors,
+				server_errors = usage.server_errors + EXCLUDED.server_errors,
+				total_duration_ms = usage.total_duration_ms + EXCLUDED.total_duration_ms
+		)
+		SELECT count(*) FROM old`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var count int64
+	err := m.DB.QueryRow(ctx, query, t, limit).Scan(&count)
+	return count, err
+}
+
+// DeleteUsageBefore removes the daily rollups for days before t and returns
+// how many were deleted.
+func (m TrustedClientModel) DeleteUsageBefore(ctx context.Context, t time.Time) (int64, error) {
+	query := `
+		DELETE FROM trusted_client_usage_daily
+		WHERE day < ($1::timestamptz AT TIME ZONE 'UTC')::date`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, t)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected(), nil
+}
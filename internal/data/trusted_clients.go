@@ -2,14 +2,14 @@ package data
 
 import (
 	"context"
-	"crypto/rand"
 	"crypto/sha256"
-	"encoding/base32"
 	"errors"
+	"net/netip"
+	"slices"
 	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shadyar-bakr/greenlight/internal/validator"
 )
 
 type TrustedClient struct {
@@ -22,63 +22,144 @@ type TrustedClient struct {
 	Enabled        bool      `json:"enabled"`
 	CreatedAt      time.Time `json:"created_at,omitempty"`
 	Version        int32     `json:"version"`
+
+	// AllowedIPs restricts the networks the client can authenticate from;
+	// empty allows any. The client can't authenticate at all after ExpiresAt,
+	// if set.
+	AllowedIPs []netip.Prefix `json:"allowed_ips"`
+	ExpiresAt  *time.Time     `json:"expires_at,omitempty"`
+
+	// KeyID is the key the client was looked up by in GetByAPIKey.
+	KeyID int64 `json:"-"`
+}
+
+// Expired reports whether the client is past its expiry.
+func (c *TrustedClient) Expired() bool {
+	return c.ExpiresAt != nil && !time.Now().Before(*c.ExpiresAt)
+}
+
+// AllowsIP reports whether the client may authenticate from ip.
+func (c *TrustedClient) AllowsIP(ip netip.Addr) bool {
+	if len(c.AllowedIPs) == 0 {
+		return true
+	}
+
+	ip = ip.Unmap()
+	return slices.ContainsFunc(c.AllowedIPs, func(prefix netip.Prefix) bool {
+		return prefix.Contains(ip)
+	})
+}
+
+func ValidateTrustedClient(v *validator.Validator, client *TrustedClient) {
+	v.Check(client.Name != "", "name", "must be provided")
+	v.Check(len(client.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(client.RateLimitRPS > 0, "rate_limit_rps", "must be greater than 0")
+	v.Check(client.RateLimitBurst > 0, "rate_limit_burst", "must be greater than 0")
+	v.Check(client.RateLimitBurst >= client.RateLimitRPS, "rate_limit_burst", "must be greater than or equal to rate_limit_rps")
+	v.Check(len(client.AllowedIPs) <= 100, "allowed_ips", "must not contain more than 100 entries")
+	v.Check(validator.Unique(client.AllowedIPs), "allowed_ips", "must not contain duplicate values")
 }
 
 type TrustedClientModel struct {
-	Pool *pgxpool.Pool
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
-// Insert adds a new trusted client to the database
-func (m TrustedClientModel) Insert(client *TrustedClient) error {
-	// Generate a random API key
-	key := make([]byte, 32)
-	_, err := rand.Read(key)
+// Insert adds a new trusted client to the database along with its first API
+// key, which is returned in client.APIKey.
+func (m TrustedClientModel) Insert(ctx context.Context, client *TrustedClient) error {
+	apiKey, hash, err := generateAPIKey()
 	if err != nil {
 		return err
 	}
-
-	// Convert to base32 for easier handling
-	client.APIKey = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
-
-	// Hash the API key for storage
-	hash := sha256.Sum256([]byte(client.APIKey))
+	client.APIKey = apiKey
 
 	query := `
-		INSERT INTO trusted_clients (name, description, api_key_hash, rate_limit_rps, rate_limit_burst, enabled)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, created_at, version`
+		WITH client AS (
+			INSERT INTO trusted_clients (name, description, rate_limit_rps, rate_limit_burst, enabled, allowed_ips, expires_at)
+			VALUES ($1, $2, $3, $4, $5, COALESCE($6, '{}'::cidr[]), $7)
+			RETURNING id, created_at, version
+		)
+		INSERT INTO trusted_client_keys (client_id, prefix, hash)
+		SELECT id, $8, $9 FROM client
+		RETURNING client_id, (SELECT created_at FROM client), (SELECT version FROM client)`
 
 	args := []any{
 		client.Name,
 		client.Description,
-		hash[:],
 		client.RateLimitRPS,
 		client.RateLimitBurst,
 		client.Enabled,
+		client.AllowedIPs,
+		client.ExpiresAt,
+		apiKey[:apiKeyPrefixLength],
+		hash,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, args...).Scan(&client.ID, &client.CreatedAt, &client.Version)
+}
+
+// Get retrieves a trusted client by ID
+func (m TrustedClientModel) Get(ctx context.Context, id int64) (*TrustedClient, error) {
+	query := `
+		SELECT id, name, description, rate_limit_rps, rate_limit_burst, enabled, created_at, version, allowed_ips, expires_at
+		FROM trusted_clients
+		WHERE id = $1`
+
+	var client TrustedClient
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	return m.Pool.QueryRow(ctx, query, args...).Scan(&client.ID, &client.CreatedAt, &client.Version)
+	err := m.DB.QueryRow(ctx, query, id).Scan(
+		&client.ID,
+		&client.Name,
+		&client.Description,
+		&client.RateLimitRPS,
+		&client.RateLimitBurst,
+		&client.Enabled,
+		&client.CreatedAt,
+		&client.Version,
+		&client.AllowedIPs,
+		&client.ExpiresAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &client, nil
 }
 
-// GetByAPIKey retrieves a trusted client by their API key
-func (m TrustedClientModel) GetByAPIKey(apiKey string) (*TrustedClient, error) {
+// GetByAPIKey retrieves a trusted client by one of its API keys that hasn't
+// expired
+func (m TrustedClientModel) GetByAPIKey(ctx context.Context, apiKey string) (*TrustedClient, error) {
 	// Hash the API key for lookup
 	hash := sha256.Sum256([]byte(apiKey))
 
 	query := `
-		SELECT id, name, description, rate_limit_rps, rate_limit_burst, enabled, created_at, version
+		SELECT trusted_clients.id, trusted_clients.name, trusted_clients.description, trusted_clients.rate_limit_rps,
+			trusted_clients.rate_limit_burst, trusted_clients.enabled, trusted_clients.created_at, trusted_clients.version,
+			trusted_clients.allowed_ips, trusted_clients.expires_at, trusted_client_keys.id
 		FROM trusted_clients
-		WHERE api_key_hash = $1`
+		INNER JOIN trusted_client_keys ON trusted_client_keys.client_id = trusted_clients.id
+		WHERE trusted_client_keys.hash = $1
+		AND (trusted_client_keys.expires_at IS NULL OR trusted_client_keys.expires_at > NOW())`
 
 	var client TrustedClient
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	err := m.Pool.QueryRow(ctx, query, hash[:]).Scan(
+	err := m.DB.QueryRow(ctx, query, hash[:]).Scan(
 		&client.ID,
 		&client.Name,
 		&client.Description,
@@ -87,6 +168,9 @@ func (m TrustedClientModel) GetByAPIKey(apiKey string) (*TrustedClient, error) {
 		&client.Enabled,
 		&client.CreatedAt,
 		&client.Version,
+		&client.AllowedIPs,
+		&client.ExpiresAt,
+		&client.KeyID,
 	)
 
 	if err != nil {
@@ -102,11 +186,12 @@ func (m TrustedClientModel) GetByAPIKey(apiKey string) (*TrustedClient, error) {
 }
 
 // Update updates a trusted client's details
-func (m TrustedClientModel) Update(client *TrustedClient) error {
+func (m TrustedClientModel) Update(ctx context.Context, client *TrustedClient) error {
 	query := `
 		UPDATE trusted_clients
-		SET name = $1, description = $2, rate_limit_rps = $3, rate_limit_burst = $4, enabled = $5, version = version + 1
-		WHERE id = $6 AND version = $7
+		SET name = $1, description = $2, rate_limit_rps = $3, rate_limit_burst = $4, enabled = $5,
+			allowed_ips = COALESCE($6, '{}'::cidr[]), expires_at = $7, version = version + 1
+		WHERE id = $8 AND version = $9
 		RETURNING version`
 
 	args := []any{
@@ -115,14 +200,16 @@ func (m TrustedClientModel) Update(client *TrustedClient) error {
 		client.RateLimitRPS,
 		client.RateLimitBurst,
 		client.Enabled,
+		client.AllowedIPs,
+		client.ExpiresAt,
 		client.ID,
 		client.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	err := m.Pool.QueryRow(ctx, query, args...).Scan(&client.Version)
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&client.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -135,30 +222,31 @@ func (m TrustedClientModel) Update(client *TrustedClient) error {
 	return nil
 }
 
-// LogRequest logs an API request from a trusted client
-func (m TrustedClientModel) LogRequest(clientID int64, endpoint, method string, statusCode int) error {
+// LogRequest logs an API request from a trusted client along with the status
+// it was answered with and how long that took.
+func (m TrustedClientModel) LogRequest(ctx context.Context, clientID int64, endpoint, method string, statusCode int, duration time.Duration) error {
 	query := `
-		INSERT INTO trusted_client_logs (client_id, endpoint, method, status_code)
-		VALUES ($1, $2, $3, $4)`
+		INSERT INTO trusted_client_logs (client_id, endpoint, method, status_code, duration_ms)
+		VALUES ($1, $2, $3, $4, $5)`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	_, err := m.Pool.Exec(ctx, query, clientID, endpoint, method, statusCode)
+	_, err := m.DB.Exec(ctx, query, clientID, endpoint, method, statusCode, float64(duration)/float64(time.Millisecond))
 	return err
 }
 
 // GetAll retrieves all trusted clients
-func (m TrustedClientModel) GetAll() ([]*TrustedClient, error) {
+func (m TrustedClientModel) GetAll(ctx context.Context) ([]*TrustedClient, error) {
 	query := `
-		SELECT id, name, description, rate_limit_rps, rate_limit_burst, enabled, created_at, version
+		SELECT id, name, description, rate_limit_rps, rate_limit_burst, enabled, created_at, version, allowed_ips, expires_at
 		FROM trusted_clients
 		ORDER BY id`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	rows, err := m.Pool.Query(ctx, query)
+	rows, err := m.DB.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -178,6 +266,8 @@ func (m TrustedClientModel) GetAll() ([]*TrustedClient, error) {
 			&client.Enabled,
 			&client.CreatedAt,
 			&client.Version,
+			&client.AllowedIPs,
+			&client.ExpiresAt,
 		)
 		if err != nil {
 			return nil, err
@@ -194,15 +284,15 @@ func (m TrustedClientModel) GetAll() ([]*TrustedClient, error) {
 }
 
 // Delete removes a trusted client
-func (m TrustedClientModel) Delete(id int64) error {
+func (m TrustedClientModel) Delete(ctx context.Context, id int64) error {
 	query := `
 		DELETE FROM trusted_clients
 		WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	result, err := m.Pool.Exec(ctx, query, id)
+	result, err := m.DB.Exec(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -213,41 +303,3 @@ func (m TrustedClientModel) Delete(id int64) error {
 
 	return nil
 }
-
-// RegenerateAPIKey generates a new API key for a trusted client
-func (m TrustedClientModel) RegenerateAPIKey(id int64) (string, error) {
-	// Generate a new random API key
-	key := make([]byte, 32)
-	_, err := rand.Read(key)
-	if err != nil {
-		return "", err
-	}
-
-	// Convert to base32 for easier handling
-	apiKey := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
-
-	// Hash the API key for storage
-	hash := sha256.Sum256([]byte(apiKey))
-
-	query := `
-		UPDATE trusted_clients
-		SET api_key_hash = $1, version = version + 1
-		WHERE id = $2
-		RETURNING version`
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	var version int32
-	err = m.Pool.QueryRow(ctx, query, hash[:], id).Scan(&version)
-	if err != nil {
-		switch {
-		case errors.Is(err, pgx.ErrNoRows):
-			return "", ErrRecordNotFound
-		default:
-			return "", err
-		}
-	}
-
-	return apiKey, nil
-}
@@ -8,7 +8,6 @@ import (
 	"crypto/sha256"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/shadyar-bakr/greenlight/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -20,6 +19,7 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  Password  `json:"-"`
 	Activated bool      `json:"activated"`
+	Locale    string    `json:"locale"`
 	Version   int32     `json:"-"`
 }
 
@@ -40,10 +40,12 @@ var (
 	ErrDuplicateEmail         = errors.New("duplicate email")
 	ErrNameRequired           = errors.New("name is required")
 	ErrNameTooLong            = errors.New("name must not be more than 500 bytes long")
+	ErrLocaleInvalid          = errors.New("locale must be a valid language tag")
 )
 
 type UserModel struct {
-	Pool *pgxpool.Pool
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -57,11 +59,16 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(len(password) <= 72, "password", ErrPasswordTooLong.Error())
 }
 
+func ValidateLocale(v *validator.Validator, locale string) {
+	v.Check(len(locale) <= 35 && validator.Matches(locale, validator.LocaleRX), "locale", ErrLocaleInvalid.Error())
+}
+
 func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(user.Name != "", "name", ErrNameRequired.Error())
 	v.Check(len(user.Name) <= 500, "name", ErrNameTooLong.Error())
 
 	ValidateEmail(v, user.Email)
+	ValidateLocale(v, user.Locale)
 
 	if user.Password.Plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.Plaintext)
@@ -98,17 +105,20 @@ func (p *Password) Matches(plaintextPassword string) (bool, error) {
 
 func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
-		INSERT INTO users (name, email, password_hash, activated)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO users (name, email, password_hash, activated, locale)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, created_at, version
 	`
 
-	args := []any{user.Name, user.Email, user.Password.Hash, user.Activated}
+	args := []any{user.Name, user.Email, user.Password.Hash, user.Activated, user.Locale}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
 
-	err := m.Pool.QueryRow(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err, "users_email_key"):
 			return ErrDuplicateEmail
 		default:
 			return err
@@ -120,20 +130,24 @@ func (m UserModel) Insert(ctx context.Context, user *User) error {
 
 func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, locale, version
 		FROM users
 		WHERE email = $1
 	`
 
 	var user User
 
-	err := m.Pool.QueryRow(ctx, query, email).Scan(
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, email).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
 		&user.Password.Hash,
 		&user.Activated,
+		&user.Locale,
 		&user.Version,
 	)
 
@@ -152,17 +166,20 @@ func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error)
 func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 		UPDATE users
-		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		SET name = $1, email = $2, password_hash = $3, activated = $4, locale = $5, version = version + 1
+		WHERE id = $6 AND version = $7
 		RETURNING version
 	`
 
-	args := []any{user.Name, user.Email, user.Password.Hash, user.Activated, user.ID, user.Version}
+	args := []any{user.Name, user.Email, user.Password.Hash, user.Activated, user.Locale, user.ID, user.Version}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
 
-	err := m.Pool.QueryRow(ctx, query, args...).Scan(&user.Version)
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err, "users_email_key"):
 			return ErrDuplicateEmail
 		case errors.Is(err, pgx.ErrNoRows):
 			return ErrEditConflict
@@ -179,7 +196,7 @@ func (m UserModel) GetForToken(ctx context.Context, scope string, tokenPlaintext
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.locale, users.version
 		FROM users
 		INNER JOIN tokens ON users.id = tokens.user_id
 		WHERE tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > now()
@@ -188,13 +205,17 @@ func (m UserModel) GetForToken(ctx context.Context, scope string, tokenPlaintext
 	var user User
 
 	// Use the hashed token value in the query
-	err := m.Pool.QueryRow(ctx, query, tokenHash[:], scope).Scan(
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, tokenHash[:], scope).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
 		&user.Password.Hash,
 		&user.Activated,
+		&user.Locale,
 		&user.Version,
 	)
 
@@ -0,0 +1,274 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/shadyar-bakr/greenlight/internal/data"
+	"github.com/shadyar-bakr/greenlight/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultMaxAttempts = 5
+
+// Handler processes the raw payload of a job. Returning an error schedules a
+// retry with backoff, unless the error is wrapped with Permanent.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+type Config struct {
+	Workers      int
+	PollInterval time.Duration
+	LockTimeout  time.Duration
+	MaxBackoff   time.Duration
+}
+
+// Queue runs a pool of workers that claim jobs from the jobs table and
+// dispatch them to the handler registered for their kind.
+type Queue struct {
+	models   data.JobModel
+	logger   *slog.Logger
+	config   Config
+	handlers map[string]Handler
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(models data.JobModel, logger *slog.Logger, config Config) *Queue {
+	return &Queue{
+		models:   models,
+		logger:   logger,
+		config:   config,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Register adds a typed handler for a job kind. The payload is decoded into T
+// before fn is called; payloads that fail to decode are moved straight to the
+// dead-letter state. Register must be called before Start.
+func Register[T any](q *Queue, kind string, fn func(ctx context.Context, payload T) error) {
+	q.handlers[kind] = func(ctx context.Context, raw json.RawMessage) error {
+		var payload T
+
+		err := json.Unmarshal(raw, &payload)
+		if err != nil {
+			return Permanent(fmt.Errorf("decode %s payload: %w", kind, err))
+		}
+
+		return fn(ctx, payload)
+	}
+}
+
+// NewJob builds a job of the given kind ready to be passed to JobModel.Insert.
+// Inserting through a transaction-scoped JobModel enqueues the job atomically
+// with the rest of the transaction.
+func NewJob(kind string, payload any) (*data.Job, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Job{
+		Kind:        kind,
+		Payload:     js,
+		MaxAttempts: defaultMaxAttempts,
+	}, nil
+}
+
+// Enqueue builds and inserts a job in one step.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload any) (*data.Job, error) {
+	job, err := NewJob(kind, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	err = q.models.Insert(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Start launches the worker pool. Workers keep polling until Shutdown is called.
+func (q *Queue) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+
+	for i := 0; i < q.config.Workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			q.work(ctx)
+		}()
+	}
+
+	q.logger.Info("started job workers", "workers", q.config.Workers)
+}
+
+// Shutdown stops workers from claiming new jobs and waits for in-flight jobs
+// to finish, or for ctx to be done. Jobs still running when ctx expires are
+// picked up again by another worker once their lock times out.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	if q.cancel == nil {
+		return nil
+	}
+	q.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		job, err := q.models.Dequeue(ctx, q.config.LockTimeout)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) && ctx.Err() == nil {
+				q.logger.Error("unable to dequeue job", "error", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(q.config.PollInterval):
+			}
+			continue
+		}
+
+		q.run(job)
+	}
+}
+
+// run executes a single job. It deliberately uses a fresh context rather than
+// the worker's so that shutting down lets the job finish instead of aborting it.
+// The handler's context is cancelled after runTimeout, before the job's lock
+// times out, so that a slow job is never run twice at the same time.
+func (q *Queue) run(job *data.Job) {
+	ctx, span := otel.Tracer("github.com/shadyar-bakr/greenlight/internal/jobs").Start(context.Background(), "job "+job.Kind,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.Int64("job.id", job.ID),
+			attribute.Int("job.attempt", job.Attempts),
+		),
+	)
+	defer span.End()
+
+	logger := q.logger.With("job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+	if sc := span.SpanContext(); sc.IsValid() {
+		logger = logger.With("trace_id", sc.TraceID().String())
+	}
+	ctx = logging.NewContext(ctx, logger)
+
+	// The outcome is recorded with ctx rather than runCtx so that it can
+	// still be written after the handler has run out of time.
+	runCtx, cancel := context.WithTimeout(ctx, q.runTimeout())
+	err := q.dispatch(runCtx, job)
+	cancel()
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	if err == nil {
+		q.finish(logger, "complete", q.models.Complete(ctx, job.ID, job.Attempts))
+		return
+	}
+
+	if isPermanent(err) || job.Attempts >= job.MaxAttempts {
+		logger.Error("job failed permanently", "error", err)
+		q.finish(logger, "bury", q.models.Bury(ctx, job.ID, job.Attempts, err.Error()))
+		return
+	}
+
+	runAt := time.Now().Add(q.backoff(job.Attempts))
+	logger.Warn("job failed, retrying", "error", err, "run_at", runAt)
+
+	q.finish(logger, "reschedule", q.models.Fail(ctx, job.ID, job.Attempts, err.Error(), runAt))
+}
+
+// finish logs the error, if any, from recording the outcome of a job.
+func (q *Queue) finish(logger *slog.Logger, action string, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, data.ErrEditConflict):
+		logger.Warn("job was reclaimed by another worker before it finished; unable to " + action + " job")
+	default:
+		logger.Error("unable to "+action+" job", "error", err)
+	}
+}
+
+// runTimeout is how long a handler may run: nine tenths of the lock timeout,
+// leaving time to record the outcome before another worker could reclaim
+// the job.
+func (q *Queue) runTimeout() time.Duration {
+	return q.config.LockTimeout * 9 / 10
+}
+
+func (q *Queue) dispatch(ctx context.Context, job *data.Job) (err error) {
+	handler, ok := q.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler registered for job kind %q", job.Kind))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return handler(ctx, job.Payload)
+}
+
+// backoff doubles the delay after each attempt, starting at five seconds and
+// capped at MaxBackoff.
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := 5 * time.Second
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= q.config.MaxBackoff {
+			return q.config.MaxBackoff
+		}
+	}
+	return delay
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying, so the job is moved to the
+// dead-letter state immediately.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var pe permanentError
+	return errors.As(err, &pe)
+}
@@ -0,0 +1,42 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	q := &Queue{config: Config{MaxBackoff: time.Minute}}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: 5 * time.Second},
+		{attempts: 1, want: 5 * time.Second},
+		{attempts: 2, want: 10 * time.Second},
+		{attempts: 3, want: 20 * time.Second},
+		{attempts: 4, want: 40 * time.Second},
+		{attempts: 5, want: time.Minute},
+		{attempts: 6, want: time.Minute},
+		{attempts: 1000, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		got := q.backoff(tt.attempts)
+		if got != tt.want {
+			t.Errorf("backoff(%d) = %s; want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRunTimeoutShorterThanLockTimeout(t *testing.T) {
+	for _, lockTimeout := range []time.Duration{10 * time.Second, time.Minute, 10 * time.Minute} {
+		q := &Queue{config: Config{LockTimeout: lockTimeout}}
+
+		got := q.runTimeout()
+		if got <= 0 || got >= lockTimeout {
+			t.Errorf("runTimeout() = %s with a lock timeout of %s; want between 0 and the lock timeout", got, lockTimeout)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+)
+
+type contextKey struct{}
+
+// New returns a logger writing to w in the given format ("text" or "json").
+// Timestamps are left out of text output, which is meant for a terminal.
+func New(w io.Writer, format string, level slog.Leveler, addSource bool) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{
+		Level:     level,
+		AddSource: addSource,
+	}
+
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		}
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format: %s", format)
+	}
+}
+
+// NewContext returns a copy of ctx carrying logger. The logger is held by
+// reference, so attributes added further down the call chain with With are
+// also seen by callers holding the parent context, such as the access log.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	ref := new(atomic.Pointer[slog.Logger])
+	ref.Store(logger)
+	return context.WithValue(ctx, contextKey{}, ref)
+}
+
+// FromContext returns the logger stored in ctx, or slog.Default if there is
+// none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if ref, ok := ctx.Value(contextKey{}).(*atomic.Pointer[slog.Logger]); ok {
+		return ref.Load()
+	}
+	return slog.Default()
+}
+
+// With adds attributes to the logger stored in ctx. It does nothing if ctx
+// has no logger.
+func With(ctx context.Context, args ...any) {
+	if ref, ok := ctx.Value(contextKey{}).(*atomic.Pointer[slog.Logger]); ok {
+		ref.Store(ref.Load().With(args...))
+	}
+}
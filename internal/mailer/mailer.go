@@ -2,41 +2,111 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"errors"
+	"fmt"
 	"html/template"
-	"time"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
 
-	"github.com/go-mail/mail"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //go:embed templates
 var templates embed.FS
 
-type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+// DefaultLocale is used when no template exists for a recipient's locale.
+const DefaultLocale = "en"
+
+// Message is a fully rendered email ready to be handed to a Transport.
+type Message struct {
+	// Template is the template file the message was rendered from.
+	Template  string
+	To        string
+	From      string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Transport delivers rendered messages. Implementations exist for SMTP, for
+// writing .eml files to disk, for capturing messages in memory and for simply
+// logging them. Send should give up once ctx is done.
+type Transport interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Pinger is implemented by transports that talk to a remote server and can
+// check that it is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type Config struct {
+	Sender string
+	// BaseURL is exposed to templates as {{baseURL}} so that links and
+	// endpoint references match the deployment.
+	BaseURL string
+	// TemplateDir optionally points at a directory laid out like the
+	// embedded templates (layout.tmpl plus one sub-directory per locale).
+	// Files found there take precedence over the embedded ones.
+	TemplateDir string
 }
 
-type SMTPConfig struct {
-	Host     string
-	Port     int
-	Username string
-	Password string
-	Sender   string
+type Mailer struct {
+	tracer    trace.Tracer
+	transport Transport
+	sender    string
+	baseURL   string
+	sources   []fs.FS
+
+	mu    sync.RWMutex
+	cache map[string]*template.Template
 }
 
-func New(config SMTPConfig) (*Mailer, error) {
-	dialer := mail.NewDialer(config.Host, config.Port, config.Username, config.Password)
-	dialer.Timeout = 5 * time.Second
+func New(transport Transport, config Config) *Mailer {
+	embedded, err := fs.Sub(templates, "templates")
+	if err != nil {
+		panic(err)
+	}
+
+	sources := []fs.FS{embedded}
+	if config.TemplateDir != "" {
+		sources = append([]fs.FS{os.DirFS(config.TemplateDir)}, sources...)
+	}
 
 	return &Mailer{
-		dialer: dialer,
-		sender: config.Sender,
-	}, nil
+		tracer:    otel.Tracer("github.com/shadyar-bakr/greenlight/internal/mailer"),
+		transport: transport,
+		sender:    config.Sender,
+		baseURL:   strings.TrimSuffix(config.BaseURL, "/"),
+		sources:   sources,
+		cache:     make(map[string]*template.Template),
+	}
 }
 
-func (m *Mailer) Send(recipient, templateFile string, data any) error {
-	tmpl, err := template.ParseFS(templates, "templates/"+templateFile)
+// Send renders templateFile in the recipient's locale, falling back to the
+// base language and then to DefaultLocale, and hands it to the transport.
+func (m *Mailer) Send(ctx context.Context, recipient, locale, templateFile string, data any) (err error) {
+	ctx, span := m.tracer.Start(ctx, "mailer.Send", trace.WithAttributes(
+		attribute.String("mail.template", templateFile),
+		attribute.String("mail.locale", locale),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	tmpl, err := m.template(locale, templateFile)
 	if err != nil {
 		return err
 	}
@@ -59,21 +129,122 @@ func (m *Mailer) Send(recipient, templateFile string, data any) error {
 		return err
 	}
 
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	for i := 0; i < 3; i++ {
-		err = m.dialer.DialAndSend(msg)
-		if nil == err {
-			return nil
+	return m.transport.Send(ctx, &Message{
+		Template:  templateFile,
+		To:        recipient,
+		From:      m.sender,
+		Subject:   strings.TrimSpace(subject.String()),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	})
+}
+
+// template returns the parsed template for a locale, parsing it on first use.
+// Templates are cached for the lifetime of the Mailer, keyed by the locale
+// directory they were found in rather than the locale asked for, so that the
+// cache can't grow with every variant a user supplies.
+func (m *Mailer) template(locale, name string) (*template.Template, error) {
+	for _, candidate := range localeCandidates(locale) {
+		key := candidate + "/" + name
+
+		m.mu.RLock()
+		tmpl, ok := m.cache[key]
+		m.mu.RUnlock()
+		if ok {
+			return tmpl, nil
+		}
+
+		content, err := m.readFile(key)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl, err = m.parse(content)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", key, err)
 		}
 
-		time.Sleep(500 * time.Millisecond)
+		m.mu.Lock()
+		m.cache[key] = tmpl
+		m.mu.Unlock()
+
+		return tmpl, nil
 	}
 
-	return err
+	return nil, fmt.Errorf("mailer: no %s template for locale %q", name, locale)
+}
+
+func (m *Mailer) parse(content []byte) (*template.Template, error) {
+	layout, err := m.readFile("layout.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := template.New("email").Funcs(template.FuncMap{
+		"baseURL": func() string { return m.baseURL },
+	})
+
+	tmpl, err = tmpl.Parse(string(layout))
+	if err != nil {
+		return nil, err
+	}
+
+	return tmpl.Parse(string(content))
+}
+
+// readFile returns the named file from the first source that has it.
+func (m *Mailer) readFile(name string) ([]byte, error) {
+	for _, source := range m.sources {
+		content, err := fs.ReadFile(source, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return content, err
+	}
+
+	return nil, fs.ErrNotExist
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(locale)), "_", "-")
+}
+
+// localeCandidates lists the template directories to try for a locale, most
+// specific first: "pt-BR" gives pt-br, pt, then DefaultLocale.
+func localeCandidates(locale string) []string {
+	var candidates []string
+
+	add := func(candidate string) {
+		if candidate == "" || !fs.ValidPath(candidate) || strings.Contains(candidate, "/") {
+			return
+		}
+		for _, c := range candidates {
+			if c == candidate {
+				return
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	locale = normalizeLocale(locale)
+	add(locale)
+
+	if base, _, found := strings.Cut(locale, "-"); found {
+		add(base)
+	}
+
+	add(DefaultLocale)
+	return candidates
+}
+
+// Ping checks that the transport's server is reachable. Transports that don't
+// implement Pinger are always considered reachable.
+func (m *Mailer) Ping(ctx context.Context) error {
+	if p, ok := m.transport.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
 }
@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTemplateCacheKeyedByResolvedLocale(t *testing.T) {
+	m := New(NewMemoryTransport(), Config{})
+
+	locales := []string{"en", "en-US", "en_GB", "en-x-foo", "EN", "fr-FR", "", "es", "es-MX"}
+	for _, locale := range locales {
+		_, err := m.template(locale, "user_welcome.tmpl")
+		if err != nil {
+			t.Fatalf("template(%q): %v", locale, err)
+		}
+	}
+
+	var keys []string
+	for key := range m.cache {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	want := []string{"en/user_welcome.tmpl", "es/user_welcome.tmpl"}
+	if !slices.Equal(keys, want) {
+		t.Errorf("cache keys = %q; want %q", keys, want)
+	}
+
+	en, _ := m.template("en", "user_welcome.tmpl")
+	enUS, _ := m.template("en-US", "user_welcome.tmpl")
+	if en != enUS {
+		t.Error("en-US parsed its own copy of the en template")
+	}
+}
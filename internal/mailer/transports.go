@@ -0,0 +1,183 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-mail/mail"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// SMTPTransport sends messages through an SMTP server, retrying a few times
+// on failure.
+type SMTPTransport struct {
+	dialer *mail.Dialer
+}
+
+func NewSMTPTransport(config SMTPConfig) *SMTPTransport {
+	dialer := mail.NewDialer(config.Host, config.Port, config.Username, config.Password)
+	dialer.Timeout = 5 * time.Second
+
+	return &SMTPTransport{dialer: dialer}
+}
+
+// Send delivers msg, making up to three attempts. It stops retrying once ctx
+// is done; an attempt already under way is bounded by the dial timeout.
+func (t *SMTPTransport) Send(ctx context.Context, msg *Message) error {
+	m := newMailMessage(msg)
+
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w (last attempt: %w)", ctx.Err(), err)
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+
+		err = t.dialer.DialAndSend(m)
+		if nil == err {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// Ping opens and immediately closes a TCP connection to the SMTP server.
+func (t *SMTPTransport) Ping(ctx context.Context) error {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(t.dialer.Host, strconv.Itoa(t.dialer.Port)))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// FileTransport writes each message to its own .eml file in a directory, where
+// it can be opened with any mail client.
+type FileTransport struct {
+	dir string
+}
+
+func NewFileTransport(dir string) (*FileTransport, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileTransport{dir: dir}, nil
+}
+
+func (t *FileTransport) Send(_ context.Context, msg *Message) error {
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	f, err := os.Create(filepath.Join(t.dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = newMailMessage(msg).WriteTo(f)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// MemoryTransport keeps every message in memory. It is intended for tests,
+// which can inspect Messages to find things like activation tokens.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (t *MemoryTransport) Send(_ context.Context, msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = append(t.messages, *msg)
+	return nil
+}
+
+// Messages returns a copy of the messages sent so far, oldest first.
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	messages := make([]Message, len(t.messages))
+	copy(messages, t.messages)
+	return messages
+}
+
+// Reset discards all captured messages.
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+}
+
+// LogTransport writes messages to a logger instead of delivering them. Only
+// the envelope is logged: bodies carry activation and unlock tokens that
+// must not end up in the logs.
+type LogTransport struct {
+	logger *slog.Logger
+}
+
+func NewLogTransport(logger *slog.Logger) *LogTransport {
+	return &LogTransport{logger: logger}
+}
+
+func (t *LogTransport) Send(ctx context.Context, msg *Message) error {
+	t.logger.InfoContext(ctx, "email sent",
+		"to", msg.To,
+		"from", msg.From,
+		"subject", msg.Subject,
+		"template", msg.Template,
+	)
+	return nil
+}
+
+func newMailMessage(msg *Message) *mail.Message {
+	m := mail.NewMessage()
+	m.SetHeader("To", msg.To)
+	m.SetHeader("From", msg.From)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.PlainBody)
+	m.AddAlternative("text/html", msg.HTMLBody)
+	return m
+}
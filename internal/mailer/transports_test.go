@@ -0,0 +1,114 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryTransportCapturesActivationToken(t *testing.T) {
+	transport := NewMemoryTransport()
+	m := New(transport, Config{Sender: "Greenlight <no-reply@greenlight.test>", BaseURL: "https://api.greenlight.test/"})
+
+	const token = "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"
+
+	err := m.Send(context.Background(), "alice@example.com", "en", "user_welcome.tmpl", map[string]any{
+		"activationToken": token,
+		"userID":          42,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := transport.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages; want 1", len(messages))
+	}
+
+	msg := messages[0]
+	if msg.To != "alice@example.com" {
+		t.Errorf("got recipient %q; want %q", msg.To, "alice@example.com")
+	}
+	if msg.Template != "user_welcome.tmpl" {
+		t.Errorf("got template %q; want %q", msg.Template, "user_welcome.tmpl")
+	}
+	if !strings.Contains(msg.PlainBody, `{"token": "`+token+`"}`) {
+		t.Errorf("activation token missing from plain body:\n%s", msg.PlainBody)
+	}
+	if !strings.Contains(msg.PlainBody, "https://api.greenlight.test/v1/users/activated") {
+		t.Errorf("activation URL missing from plain body:\n%s", msg.PlainBody)
+	}
+
+	transport.Reset()
+	if n := len(transport.Messages()); n != 0 {
+		t.Errorf("got %d messages after Reset; want 0", n)
+	}
+}
+
+func TestLogTransportOmitsBody(t *testing.T) {
+	var buf bytes.Buffer
+	transport := NewLogTransport(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	err := transport.Send(context.Background(), &Message{
+		Template:  "user_welcome.tmpl",
+		To:        "alice@example.com",
+		Subject:   "Welcome to Greenlight!",
+		PlainBody: "secret-plain-token",
+		HTMLBody:  "secret-html-token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logged := buf.String()
+	for _, want := range []string{"alice@example.com", "Welcome to Greenlight!", "user_welcome.tmpl"} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log entry missing %q: %s", want, logged)
+		}
+	}
+	for _, secret := range []string{"secret-plain-token", "secret-html-token"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("log entry contains message body %q: %s", secret, logged)
+		}
+	}
+}
+
+func TestSMTPTransportStopsRetryingWhenCancelled(t *testing.T) {
+	// Nothing listens on the port, so every attempt fails straight away.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close()
+
+	transport := NewSMTPTransport(SMTPConfig{Host: "127.0.0.1", Port: addr.Port})
+	msg := &Message{To: "alice@example.com", From: "greenlight@example.com", Subject: "Hello"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = transport.Send(ctx, msg)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v; want context.DeadlineExceeded", err)
+	}
+	// Without cancellation the two pauses between attempts alone take a
+	// second.
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Send took %s after its context expired", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = transport.Send(cancelled, msg)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v for a cancelled context; want context.Canceled", err)
+	}
+}
@@ -0,0 +1,38 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigserial PRIMARY KEY,
+    kind text NOT NULL,
+    payload jsonb NOT NULL DEFAULT '{}',
+    status text NOT NULL DEFAULT 'queued',
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL DEFAULT 5,
+    last_error text,
+    run_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    locked_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Add job constraints
+ALTER TABLE jobs ADD CONSTRAINT jobs_status_check CHECK (status IN ('queued', 'running', 'succeeded', 'dead'));
+ALTER TABLE jobs ADD CONSTRAINT jobs_max_attempts_check CHECK (max_attempts >= 1);
+
+-- Add job indexes
+CREATE INDEX IF NOT EXISTS jobs_ready_idx ON jobs(run_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS jobs_running_idx ON jobs(locked_at) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs(status);
+
+-- Seed job administration permission
+INSERT INTO permissions (code) VALUES ('jobs:write') ON CONFLICT DO NOTHING;
+
+COMMIT;
+
+---- create above / drop below ----
+
+BEGIN;
+
+DELETE FROM permissions WHERE code = 'jobs:write';
+DROP TABLE IF EXISTS jobs CASCADE;
+
+COMMIT;
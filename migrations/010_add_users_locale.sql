@@ -0,0 +1,13 @@
+BEGIN;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale text NOT NULL DEFAULT 'en';
+
+COMMIT;
+
+---- create above / drop below ----
+
+BEGIN;
+
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+
+COMMIT;
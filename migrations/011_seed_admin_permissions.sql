@@ -0,0 +1,40 @@
+BEGIN;
+
+-- Seed administration permissions
+INSERT INTO permissions (code) VALUES
+    ('roles:write'),
+    ('trusted-clients:write')
+ON CONFLICT DO NOTHING;
+
+-- Grant the default roles their permissions
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles
+CROSS JOIN permissions
+WHERE roles.name = 'admin'
+OR (roles.name = 'manager' AND permissions.code IN ('movies:read', 'movies:write'))
+OR (roles.name = 'user' AND permissions.code = 'movies:read')
+ON CONFLICT DO NOTHING;
+
+COMMIT;
+
+---- create above / drop below ----
+
+BEGIN;
+
+-- Revoke only the grants made above, against the permissions that existed
+-- when this migration was written, so that grants made since by admins or
+-- later migrations survive.
+DELETE FROM roles_permissions
+USING roles, permissions
+WHERE roles_permissions.role_id = roles.id
+AND roles_permissions.permission_id = permissions.id
+AND (
+    (roles.name = 'admin' AND permissions.code IN ('movies:read', 'movies:write', 'jobs:write', 'roles:write', 'trusted-clients:write'))
+    OR (roles.name = 'manager' AND permissions.code IN ('movies:read', 'movies:write'))
+    OR (roles.name = 'user' AND permissions.code = 'movies:read')
+);
+
+DELETE FROM permissions WHERE code IN ('roles:write', 'trusted-clients:write');
+
+COMMIT;
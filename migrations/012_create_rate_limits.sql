@@ -0,0 +1,23 @@
+BEGIN;
+
+-- Shared rate limiter state. Each row holds the GCRA theoretical arrival time
+-- of a key; a key whose tat has passed has its full burst available again and
+-- its row can be deleted. The table is UNLOGGED because losing it in a crash
+-- only resets the limits.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+    key text PRIMARY KEY,
+    tat timestamp with time zone NOT NULL,
+    allowed boolean NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_tat_idx ON rate_limits(tat);
+
+COMMIT;
+
+---- create above / drop below ----
+
+BEGIN;
+
+DROP TABLE IF EXISTS rate_limits;
+
+COMMIT;
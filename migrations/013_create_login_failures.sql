@@ -0,0 +1,43 @@
+BEGIN;
+
+-- Failed login attempts, kept for the lockout window. user_id is NULL for
+-- attempts against unknown emails, which still count against the IP.
+CREATE TABLE IF NOT EXISTS login_failures (
+    id bigserial PRIMARY KEY,
+    user_id bigint REFERENCES users ON DELETE CASCADE,
+    ip inet NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_user_idx ON login_failures(user_id, created_at) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS login_failures_ip_idx ON login_failures(ip, created_at);
+CREATE INDEX IF NOT EXISTS login_failures_created_at_idx ON login_failures(created_at);
+
+-- Accounts temporarily locked after too many failed logins
+CREATE TABLE IF NOT EXISTS account_lockouts (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    failures integer NOT NULL,
+    locked_until timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Seed lockout administration permission
+INSERT INTO permissions (code) VALUES ('lockouts:write') ON CONFLICT DO NOTHING;
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'lockouts:write'
+ON CONFLICT DO NOTHING;
+
+COMMIT;
+
+---- create above / drop below ----
+
+BEGIN;
+
+DELETE FROM permissions WHERE code = 'lockouts:write';
+DROP TABLE IF EXISTS account_lockouts;
+DROP TABLE IF EXISTS login_failures;
+
+COMMIT;
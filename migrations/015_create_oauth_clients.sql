@@ -0,0 +1,35 @@
+BEGIN;
+
+-- Tokens can be issued to trusted clients acting on their own behalf through
+-- the OAuth client credentials grant, in which case they belong to a client
+-- rather than a user and carry the permission codes they were granted.
+ALTER TABLE tokens ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS client_id bigint REFERENCES trusted_clients ON DELETE CASCADE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS scopes text[] NOT NULL DEFAULT '{}';
+ALTER TABLE tokens ADD CONSTRAINT tokens_owner_check CHECK (user_id IS NOT NULL OR client_id IS NOT NULL);
+
+CREATE INDEX IF NOT EXISTS tokens_client_idx ON tokens(client_id) WHERE client_id IS NOT NULL;
+
+-- The permissions a trusted client may request as OAuth scopes
+CREATE TABLE IF NOT EXISTS trusted_clients_permissions (
+    client_id bigint NOT NULL REFERENCES trusted_clients ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (client_id, permission_id)
+);
+
+COMMIT;
+
+---- create above / drop below ----
+
+BEGIN;
+
+DROP TABLE IF EXISTS trusted_clients_permissions;
+
+DELETE FROM tokens WHERE user_id IS NULL;
+DROP INDEX IF EXISTS tokens_client_idx;
+ALTER TABLE tokens DROP CONSTRAINT IF EXISTS tokens_owner_check;
+ALTER TABLE tokens DROP COLUMN IF EXISTS scopes;
+ALTER TABLE tokens DROP COLUMN IF EXISTS client_id;
+ALTER TABLE tokens ALTER COLUMN user_id SET NOT NULL;
+
+COMMIT;
@@ -0,0 +1,18 @@
+BEGIN;
+
+-- Trusted clients can authenticate with their API key, optionally only from
+-- the listed networks and only until they expire. An empty allowlist allows
+-- any address.
+ALTER TABLE trusted_clients ADD COLUMN IF NOT EXISTS allowed_ips cidr[] NOT NULL DEFAULT '{}';
+ALTER TABLE trusted_clients ADD COLUMN IF NOT EXISTS expires_at timestamp(0) with time zone;
+
+COMMIT;
+
+---- create above / drop below ----
+
+BEGIN;
+
+ALTER TABLE trusted_clients DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE trusted_clients DROP COLUMN IF EXISTS allowed_ips;
+
+COMMIT;
@@ -0,0 +1,49 @@
+BEGIN;
+
+-- Trusted clients can hold several API keys so that a key can be replaced
+-- without downtime: the old key keeps working until its expires_at while
+-- consumers move to the new one. prefix is the start of the key, kept to
+-- tell keys apart; it is empty for keys created before this migration.
+CREATE TABLE IF NOT EXISTS trusted_client_keys (
+    id bigserial PRIMARY KEY,
+    client_id bigint NOT NULL REFERENCES trusted_clients ON DELETE CASCADE,
+    prefix text NOT NULL DEFAULT '',
+    hash bytea NOT NULL UNIQUE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_used_at timestamp(0) with time zone,
+    expires_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS trusted_client_keys_client_idx ON trusted_client_keys(client_id);
+
+INSERT INTO trusted_client_keys (client_id, hash, created_at)
+SELECT id, api_key_hash, created_at
+FROM trusted_clients;
+
+ALTER TABLE trusted_clients DROP COLUMN IF EXISTS api_key_hash;
+
+COMMIT;
+
+---- create above / drop below ----
+
+BEGIN;
+
+-- Keep each client's newest key. Clients without one get an unusable hash.
+ALTER TABLE trusted_clients ADD COLUMN IF NOT EXISTS api_key_hash bytea;
+
+UPDATE trusted_clients
+SET api_key_hash = COALESCE(
+    (SELECT hash FROM trusted_client_keys
+     WHERE trusted_client_keys.client_id = trusted_clients.id
+     ORDER BY created_at DESC, id DESC
+     LIMIT 1),
+    sha256(random()::text::bytea)
+);
+
+ALTER TABLE trusted_clients ALTER COLUMN api_key_hash SET NOT NULL;
+ALTER TABLE trusted_clients ADD CONSTRAINT trusted_clients_api_key_hash_key UNIQUE (api_key_hash);
+CREATE INDEX IF NOT EXISTS trusted_clients_api_key_idx ON trusted_clients(api_key_hash);
+
+DROP TABLE IF EXISTS trusted_client_keys;
+
+COMMIT;
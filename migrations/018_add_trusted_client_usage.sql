@@ -0,0 +1,32 @@
+BEGIN;
+
+-- How long each trusted client request took to serve
+ALTER TABLE trusted_client_logs ADD COLUMN IF NOT EXISTS duration_ms double precision NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS trusted_client_logs_client_timestamp_idx ON trusted_client_logs(client_id, timestamp);
+
+-- Trusted client requests past the log retention period, rolled up per UTC
+-- day, endpoint and method
+CREATE TABLE IF NOT EXISTS trusted_client_usage_daily (
+    client_id bigint NOT NULL REFERENCES trusted_clients ON DELETE CASCADE,
+    day date NOT NULL,
+    endpoint text NOT NULL,
+    method text NOT NULL,
+    requests bigint NOT NULL,
+    client_errors bigint NOT NULL,
+    server_errors bigint NOT NULL,
+    total_duration_ms double precision NOT NULL,
+    PRIMARY KEY (client_id, day, endpoint, method)
+);
+
+COMMIT;
+
+---- create above / drop below ----
+
+BEGIN;
+
+DROP TABLE IF EXISTS trusted_client_usage_daily;
+DROP INDEX IF EXISTS trusted_client_logs_client_timestamp_idx;
+ALTER TABLE trusted_client_logs DROP COLUMN IF EXISTS duration_ms;
+
+COMMIT;
@@ -0,0 +1,21 @@
+BEGIN;
+
+-- When the account and IP may next try to log in after a failed login. NULL
+-- when failed logins aren't delayed.
+ALTER TABLE login_failures ADD COLUMN IF NOT EXISTS next_attempt_at timestamp(3) with time zone;
+
+-- Failed logins from clients whose IP couldn't be determined are tracked
+-- against the account only.
+ALTER TABLE login_failures ALTER COLUMN ip DROP NOT NULL;
+
+COMMIT;
+
+---- create above / drop below ----
+
+BEGIN;
+
+DELETE FROM login_failures WHERE ip IS NULL;
+ALTER TABLE login_failures ALTER COLUMN ip SET NOT NULL;
+ALTER TABLE login_failures DROP COLUMN IF EXISTS next_attempt_at;
+
+COMMIT;
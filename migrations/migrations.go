@@ -0,0 +1,23 @@
+// Package migrations embeds the tern SQL migrations so that the API binary can
+// apply and verify its own schema.
+package migrations
+
+import (
+	"embed"
+
+	"github.com/jackc/tern/v2/migrate"
+)
+
+//go:embed *.sql
+var FS embed.FS
+
+// Latest returns the version of the newest embedded migration, which is the
+// schema version the binary expects.
+func Latest() (int, error) {
+	paths, err := migrate.FindMigrations(FS)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(paths), nil
+}